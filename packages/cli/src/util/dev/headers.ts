@@ -88,3 +88,21 @@ export function applyOverriddenHeaders(
     respHeaders.delete(valueKey);
   }
 }
+
+/**
+ * Deletes the request headers that the Vercel edge sets or strips, so that
+ * a client supplied copy never reaches a builder's dev server.
+ */
+export function stripPlatformHeaders(reqHeaders: IncomingHttpHeaders) {
+  for (const name of Object.keys(reqHeaders)) {
+    const key = name.toLowerCase();
+    if (
+      key.startsWith('x-vercel-') ||
+      key.startsWith('x-forwarded-') ||
+      key === 'x-real-ip' ||
+      key === 'forwarded'
+    ) {
+      delete reqHeaders[name];
+    }
+  }
+}
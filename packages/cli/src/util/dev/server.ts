@@ -87,7 +87,11 @@ import type {
 } from './types';
 import type { ProjectSettings } from '@vercel-internals/types';
 import { treeKill } from '../tree-kill';
-import { applyOverriddenHeaders, nodeHeadersToFetchHeaders } from './headers';
+import {
+  applyOverriddenHeaders,
+  nodeHeadersToFetchHeaders,
+  stripPlatformHeaders,
+} from './headers';
 import { formatQueryString, parseQueryString } from './parse-query-string';
 import {
   errorToString,
@@ -1938,7 +1942,9 @@ export default class DevServer {
           search: origUrl.search,
         });
 
-        // Add the Vercel platform proxy request headers
+        // Add the Vercel platform proxy request headers, in place of the
+        // ones the client sent
+        stripPlatformHeaders(req.headers);
         const headers = this.getProxyHeaders(req, requestId, false);
         for (const [name, value] of Object.entries(headers)) {
           req.headers[name] = value;
@@ -0,0 +1,7 @@
+module go-mod-replace/api
+
+go 1.20
+
+require go-mod-replace/mylib v0.0.0
+
+replace go-mod-replace/mylib => ../mylib
@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"go-mod-replace/mylib"
+)
+
+// Handler function
+func Handler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, mylib.Say("hello"))
+}
@@ -0,0 +1,3 @@
+module go-mod-replace/mylib
+
+go 1.20
@@ -0,0 +1,5 @@
+package mylib
+
+func Say(text string) string {
+	return text + " from mylib"
+}
@@ -182,6 +182,13 @@ test(
   })
 );
 
+test(
+  '[vercel dev] Should support `*.go` API serverless functions with a relative `replace`',
+  testFixtureStdio('go-mod-replace', async (testPath: any) => {
+    await testPath(200, `/api`, 'hello from mylib');
+  })
+);
+
 test(
   '[vercel dev] Should support `*.go` API serverless functions with `go.work` and lib',
   testFixtureStdio('go-work-with-shared', async (testPath: any) => {
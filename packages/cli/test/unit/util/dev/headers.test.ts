@@ -1,6 +1,9 @@
 import { describe, expect, it } from 'vitest';
 import { Headers } from 'node-fetch';
-import { applyOverriddenHeaders } from '../../../../src/util/dev/headers';
+import {
+  applyOverriddenHeaders,
+  stripPlatformHeaders,
+} from '../../../../src/util/dev/headers';
 
 describe('applyOverriddenHeaders', () => {
   it('do nothing if x-middleware-override-headers is not set', async () => {
@@ -76,3 +79,20 @@ describe('applyOverriddenHeaders', () => {
     expect(reqHeaders).toStrictEqual({ b: '2' });
   });
 });
+
+describe('stripPlatformHeaders', () => {
+  it('deletes the headers the edge sets', async () => {
+    const reqHeaders = {
+      accept: 'text/plain',
+      'x-vercel-id': 'spoofed',
+      'x-vercel-ip-country': 'XX',
+      'x-forwarded-for': '203.0.113.1',
+      'x-real-ip': '203.0.113.1',
+      forwarded: 'for=203.0.113.1',
+      'x-custom': '1',
+    };
+
+    stripPlatformHeaders(reqHeaders);
+    expect(reqHeaders).toStrictEqual({ accept: 'text/plain', 'x-custom': '1' });
+  });
+});
@@ -11,6 +11,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -77,6 +79,211 @@ func isInIgnoredFolder(path string) bool {
 	return false
 }
 
+// module is the main module of the entrypoint, as declared in its go.mod
+type module struct {
+	path string
+	// local directories of the modules replaced with one, or of the other
+	// modules of the workspace, by module path
+	replaced map[string]string
+}
+
+var (
+	moduleRegex  = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?`)
+	replaceRegex = regexp.MustCompile(`^(\S+)(?:\s+\S+)?\s+=>\s+(\S+)\s*$`)
+)
+
+// directives returns the arguments of the directives with the given name of a
+// go.mod or go.work, in both the single line and the block form
+func directives(data []byte, name string) []string {
+	var args []string
+	inBlock := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.SplitN(line, "//", 2)[0])
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == name && fields[1] == "(":
+			inBlock = true
+		case inBlock && line == ")":
+			inBlock = false
+		case inBlock && line != "":
+			args = append(args, line)
+		case !inBlock && len(fields) > 1 && fields[0] == name:
+			args = append(args, strings.TrimSpace(line[len(name):]))
+		}
+	}
+	return args
+}
+
+// localDir returns the directory of a local path of a go.mod or go.work in
+// dir, or false if the path is a module path
+func localDir(dir, path string) (string, bool) {
+	path = strings.Trim(path, `"`)
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		return filepath.Join(dir, filepath.FromSlash(path)), true
+	}
+	if filepath.IsAbs(path) {
+		return path, true
+	}
+	return "", false
+}
+
+// addReplaced adds the local `replace` directives of a go.mod or go.work in
+// dir
+func (m *module) addReplaced(dir string, data []byte) {
+	for _, line := range directives(data, "replace") {
+		replace := replaceRegex.FindStringSubmatch(line)
+		if replace == nil {
+			continue
+		}
+		if target, ok := localDir(dir, replace[2]); ok {
+			m.replaced[replace[1]] = target
+		}
+	}
+}
+
+// parseModule reads the module path and the local `replace` directives of the
+// go.mod in dir
+func parseModule(dir string) *module {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil
+	}
+	matches := moduleRegex.FindSubmatch(data)
+	if matches == nil {
+		return nil
+	}
+	mod := &module{path: string(matches[1]), replaced: map[string]string{}}
+	mod.addReplaced(dir, data)
+	return mod
+}
+
+// addWorkspace adds the other modules of the go.work and its local `replace`
+// directives, which take precedence over those of the go.mod
+func (m *module) addWorkspace(goWork string) {
+	data, err := ioutil.ReadFile(goWork)
+	if err != nil {
+		return
+	}
+	dir := filepath.Dir(goWork)
+	for _, use := range directives(data, "use") {
+		useDir := filepath.FromSlash(strings.Trim(use, `"`))
+		if !filepath.IsAbs(useDir) {
+			useDir = filepath.Join(dir, useDir)
+		}
+		if used := parseModule(useDir); used != nil && used.path != m.path {
+			m.replaced[used.path] = useDir
+		}
+	}
+	m.addReplaced(dir, data)
+}
+
+// resolve returns the directory of an imported package that is part of the
+// main module or of a module replaced with a local directory
+func (m *module) resolve(dir, importPath string) (string, bool) {
+	candidates := map[string]string{m.path: dir}
+	for path, replaced := range m.replaced {
+		candidates[path] = replaced
+	}
+
+	// the longest module path wins, like nested modules
+	match := ""
+	for path := range candidates {
+		if (importPath == path || strings.HasPrefix(importPath, path+"/")) && len(path) > len(match) {
+			match = path
+		}
+	}
+	if match == "" {
+		return "", false
+	}
+	return filepath.Join(candidates[match], filepath.FromSlash(strings.TrimPrefix(importPath[len(match):], "/"))), true
+}
+
+// embedded returns the files matching the `//go:embed` patterns of a file
+func embedded(dir string, parsed *ast.File) []string {
+	var files []string
+	for _, group := range parsed.Comments {
+		for _, comment := range group.List {
+			if !strings.HasPrefix(comment.Text, "//go:embed ") {
+				continue
+			}
+			for _, pattern := range strings.Fields(comment.Text[len("//go:embed "):]) {
+				if unquoted, err := strconv.Unquote(pattern); err == nil {
+					pattern = unquoted
+				}
+				pattern = strings.TrimPrefix(pattern, "all:")
+				matches, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+				for _, match := range matches {
+					filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+						if err == nil && !info.IsDir() {
+							files = append(files, path)
+						}
+						return nil
+					})
+				}
+			}
+		}
+	}
+	return files
+}
+
+// watch returns the files of the import graph of the entrypoint that are
+// within the main module, a locally replaced module or a module of the
+// workspace: the Go files of each package and the files they embed
+func watch(fileName, modPath, goWork string) []string {
+	var mod *module
+	if modPath != "" && modPath != "undefined" {
+		mod = parseModule(modPath)
+	}
+	if mod != nil && goWork != "" {
+		mod.addWorkspace(goWork)
+	}
+
+	files := []string{}
+	visited := map[string]bool{}
+	queue := []string{filepath.Dir(fileName)}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+
+		names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			files = append(files, name)
+
+			parsed, err := parser.ParseFile(token.NewFileSet(), name, nil, parser.ParseComments)
+			if err != nil {
+				continue
+			}
+			files = append(files, embedded(dir, parsed)...)
+			if mod == nil {
+				continue
+			}
+			for _, spec := range parsed.Imports {
+				importPath, err := strconv.Unquote(spec.Path.Value)
+				if err != nil {
+					continue
+				}
+				if importDir, ok := mod.resolve(modPath, importPath); ok {
+					queue = append(queue, importDir)
+				}
+			}
+		}
+	}
+
+	files = unique(files)
+	sort.Strings(files)
+	return files
+}
+
 // return unique file
 func unique(files []string) []string {
 	encountered := map[string]bool{}
@@ -92,13 +299,18 @@ func unique(files []string) []string {
 }
 
 func main() {
-	if len(os.Args) != 3 {
+	if len(os.Args) != 3 && len(os.Args) != 4 {
 		// Args should have the program name on `0`
-		// and the file name on `1`
-		fmt.Println("Wrong number of args; Usage is:\n  ./go-analyze -modpath=module-path file_name.go")
+		// and the file name last
+		fmt.Println("Wrong number of args; Usage is:\n  ./go-analyze -modpath=module-path [-gowork=go-work-path] file_name.go")
 		os.Exit(1)
 	}
-	fileName := os.Args[2]
+	modPath := strings.TrimPrefix(os.Args[1], "-modpath=")
+	goWork := ""
+	if len(os.Args) == 4 {
+		goWork = strings.TrimPrefix(os.Args[2], "-gowork=")
+	}
+	fileName := os.Args[len(os.Args)-1]
 	rf, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		log.Fatal(err)
@@ -128,6 +340,7 @@ func main() {
 				analyzed := analyze{
 					PackageName: parsed.Name.Name,
 					FuncName:    fn.Name.Name,
+					Watch:       watch(fileName, modPath, goWork),
 				}
 				analyzedJSON, _ := json.Marshal(analyzed)
 				fmt.Print(string(analyzedJSON))
@@ -149,6 +362,7 @@ func main() {
 					analyzed := analyze{
 						PackageName: parsed.Name.Name,
 						FuncName:    fn.Name.Name,
+						Watch:       watch(fileName, modPath, goWork),
 					}
 					analyzedJSON, _ := json.Marshal(analyzed)
 					fmt.Print(string(analyzedJSON))
@@ -0,0 +1,9 @@
+import { buildBuilder } from '../../utils/build-builder.mjs';
+
+await Promise.all([
+  buildBuilder(),
+  buildBuilder({
+    entryPoints: ['src/load-test-cli.ts'],
+    outfile: 'dist/load-test-cli.js',
+  }),
+]);
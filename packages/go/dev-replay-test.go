@@ -0,0 +1,139 @@
+package __VC_REPLAY_PACKAGE_NAME
+
+// Generated by `vercel dev` in record mode. The test replays the requests
+// recorded in the HAR file against the handler and compares the responses.
+// Edit the ignored headers below, or set `VERCEL_GO_REPLAY_IGNORE_HEADERS` to
+// a comma-separated list of additional headers, to skip volatile values.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReplay__VC_REPLAY_HANDLER_FUNC_NAME(t *testing.T) {
+	file := "__VC_REPLAY_HAR_FILE"
+
+	// headers whose values differ between runs
+	ignore := map[string]bool{
+		"Date":          true,
+		"Expires":       true,
+		"Last-Modified": true,
+		"Set-Cookie":    true,
+		"X-Vercel-Id":   true,
+	}
+	for _, name := range strings.Split(os.Getenv("VERCEL_GO_REPLAY_IGNORE_HEADERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ignore[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+
+	type nameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type content struct {
+		Text     string `json:"text"`
+		Encoding string `json:"encoding"`
+	}
+	var har struct {
+		Log struct {
+			Entries []struct {
+				Request struct {
+					Method   string      `json:"method"`
+					URL      string      `json:"url"`
+					Headers  []nameValue `json:"headers"`
+					PostData *content    `json:"postData"`
+				} `json:"request"`
+				Response struct {
+					Status  int         `json:"status"`
+					Headers []nameValue `json:"headers"`
+					Content content     `json:"content"`
+				} `json:"response"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		t.Skipf("no recording at %s", file)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("invalid HAR file %s: %v", file, err)
+	}
+
+	decode := func(t *testing.T, c content) []byte {
+		if c.Encoding != "base64" {
+			return []byte(c.Text)
+		}
+		body, err := base64.StdEncoding.DecodeString(c.Text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+	headers := func(pairs []nameValue) http.Header {
+		header := http.Header{}
+		for _, pair := range pairs {
+			header.Add(pair.Name, pair.Value)
+		}
+		return header
+	}
+
+	for _, entry := range har.Log.Entries {
+		entry := entry
+		t.Run(entry.Request.Method+" "+entry.Request.URL, func(t *testing.T) {
+			var body []byte
+			if entry.Request.PostData != nil {
+				body = decode(t, *entry.Request.PostData)
+			}
+			req := httptest.NewRequest(entry.Request.Method, entry.Request.URL, bytes.NewReader(body))
+			req.Header = headers(entry.Request.Headers)
+
+			rec := httptest.NewRecorder()
+			__VC_REPLAY_HANDLER_FUNC_NAME(rec, req)
+			res := rec.Result()
+
+			if res.StatusCode != entry.Response.Status {
+				t.Errorf("status: got %d, recorded %d", res.StatusCode, entry.Response.Status)
+			}
+
+			want := headers(entry.Response.Headers)
+			names := map[string]bool{}
+			for name := range want {
+				names[name] = true
+			}
+			for name := range res.Header {
+				names[name] = true
+			}
+			sorted := make([]string, 0, len(names))
+			for name := range names {
+				if !ignore[name] {
+					sorted = append(sorted, name)
+				}
+			}
+			sort.Strings(sorted)
+			for _, name := range sorted {
+				got := strings.Join(res.Header[name], ", ")
+				recorded := strings.Join(want[name], ", ")
+				if got != recorded {
+					t.Errorf("header %s: got %q, recorded %q", name, got, recorded)
+				}
+			}
+
+			if got, recorded := rec.Body.Bytes(), decode(t, entry.Response.Content); !bytes.Equal(got, recorded) {
+				t.Errorf("body:\ngot      %q\nrecorded %q", got, recorded)
+			}
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+// A load test of a Go function, driving either the dev server over HTTP or
+// the production `bootstrap` through a stand-in for the Lambda Runtime API.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestTemplate is the request sent by every worker, read from the file
+// passed with `-request`.
+type requestTemplate struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// result is printed as JSON with `-json`.
+type result struct {
+	Target      string         `json:"target"`
+	Concurrency int            `json:"concurrency"`
+	Duration    float64        `json:"durationSeconds"`
+	Requests    int            `json:"requests"`
+	Throughput  float64        `json:"requestsPerSecond"`
+	Errors      int            `json:"errors"`
+	ErrorRate   float64        `json:"errorRate"`
+	StatusCodes map[string]int `json:"statusCodes"`
+	Latency     latency        `json:"latencyMs"`
+	// -1 when not available
+	AllocsPerRequest float64 `json:"allocsPerRequest"`
+	BytesPerRequest  float64 `json:"bytesPerRequest"`
+	PeakRSS          int64   `json:"peakRssBytes"`
+}
+
+type latency struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// response is the outcome of a single request. A status of 0 means the
+// request failed.
+type response struct {
+	status  int
+	elapsed time.Duration
+}
+
+// sender sends the request template once.
+type sender func() response
+
+type memStats struct {
+	Mallocs    uint64
+	TotalAlloc uint64
+}
+
+func main() {
+	url := flag.String("url", "", "base URL of the dev server")
+	bootstrap := flag.String("bootstrap", "", "path to the production bootstrap, run with a Lambda Runtime API stand-in")
+	concurrency := flag.Int("c", 10, "number of concurrent requests")
+	duration := flag.Duration("d", 10*time.Second, "duration of the load test")
+	requestFile := flag.String("request", "", "JSON file of the request template: method, path, headers and body")
+	pid := flag.Int("pid", 0, "process of the dev server, to measure its peak RSS")
+	memstatsURL := flag.String("memstats", "", "URL of the dev server's /__vercel_go/memstats endpoint")
+	jsonOutput := flag.Bool("json", false, "print the result as JSON to stdout")
+	verbose := flag.Bool("v", false, "show the output of the bootstrap processes")
+	flag.Parse()
+
+	if (*url == "") == (*bootstrap == "") {
+		log.Fatal("exactly one of -url or -bootstrap is required")
+	}
+	if *concurrency < 1 {
+		log.Fatal("-c must be at least 1")
+	}
+
+	tmpl := requestTemplate{Method: "GET", Path: "/"}
+	if *requestFile != "" {
+		data, err := ioutil.ReadFile(*requestFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &tmpl); err != nil {
+			log.Fatalf("invalid request template %s: %v", *requestFile, err)
+		}
+	}
+
+	res := result{
+		Concurrency:      *concurrency,
+		StatusCodes:      map[string]int{},
+		AllocsPerRequest: -1,
+		BytesPerRequest:  -1,
+		PeakRSS:          -1,
+	}
+
+	if *url != "" {
+		res.Target = *url
+		var before *memStats
+		if *memstatsURL != "" {
+			before = fetchMemStats(*memstatsURL)
+		}
+		send := httpSender(strings.TrimSuffix(*url, "/"), tmpl)
+		run(send, *concurrency, *duration, &res)
+		if before != nil && res.Requests > 0 {
+			if after := fetchMemStats(*memstatsURL); after != nil {
+				res.AllocsPerRequest = float64(after.Mallocs-before.Mallocs) / float64(res.Requests)
+				res.BytesPerRequest = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Requests)
+			}
+		}
+		if *pid > 0 {
+			res.PeakRSS = peakRSS(*pid)
+		}
+	} else {
+		res.Target = *bootstrap
+		api, err := newRuntimeAPI()
+		if err != nil {
+			log.Fatal(err)
+		}
+		procs, err := api.startBootstraps(*bootstrap, *concurrency, *verbose)
+		if err != nil {
+			log.Fatal(err)
+		}
+		run(api.sender(tmpl), *concurrency, *duration, &res)
+		for _, cmd := range procs {
+			if rss := peakRSS(cmd.Process.Pid); rss > res.PeakRSS {
+				res.PeakRSS = rss
+			}
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}
+
+	report(os.Stderr, &res)
+	if *jsonOutput {
+		data, _ := json.MarshalIndent(res, "", "  ")
+		fmt.Println(string(data))
+	}
+}
+
+// run sends requests from `concurrency` workers until the duration elapsed.
+func run(send sender, concurrency int, duration time.Duration, res *result) {
+	var mu sync.Mutex
+	var elapsed []time.Duration
+	var wg sync.WaitGroup
+
+	started := time.Now()
+	deadline := started.Add(duration)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []response
+			for time.Now().Before(deadline) {
+				local = append(local, send())
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, r := range local {
+				res.Requests++
+				elapsed = append(elapsed, r.elapsed)
+				if r.status == 0 {
+					res.Errors++
+					res.StatusCodes["error"]++
+					continue
+				}
+				if r.status >= 500 {
+					res.Errors++
+				}
+				res.StatusCodes[strconv.Itoa(r.status)]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	res.Duration = time.Since(started).Seconds()
+	if res.Requests == 0 {
+		return
+	}
+	res.Throughput = float64(res.Requests) / res.Duration
+	res.ErrorRate = float64(res.Errors) / float64(res.Requests)
+
+	sort.Slice(elapsed, func(i, j int) bool { return elapsed[i] < elapsed[j] })
+	percentile := func(p float64) float64 {
+		i := int(p * float64(len(elapsed)-1))
+		return float64(elapsed[i]) / float64(time.Millisecond)
+	}
+	res.Latency = latency{
+		P50: percentile(0.50),
+		P90: percentile(0.90),
+		P99: percentile(0.99),
+		Max: percentile(1),
+	}
+}
+
+func httpSender(base string, tmpl requestTemplate) sender {
+	client := &http.Client{Transport: &http.Transport{
+		MaxIdleConnsPerHost: 1024,
+	}}
+	return func() response {
+		req, err := http.NewRequest(tmpl.Method, base+tmpl.Path, strings.NewReader(tmpl.Body))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for name, value := range tmpl.Headers {
+			req.Header.Set(name, value)
+		}
+		started := time.Now()
+		res, err := client.Do(req)
+		if err != nil {
+			return response{elapsed: time.Since(started)}
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		return response{status: res.StatusCode, elapsed: time.Since(started)}
+	}
+}
+
+func fetchMemStats(url string) *memStats {
+	res, err := http.Get(url)
+	if err != nil {
+		log.Printf("could not fetch memory statistics: %v", err)
+		return nil
+	}
+	defer res.Body.Close()
+	var stats memStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		log.Printf("could not fetch memory statistics: %v", err)
+		return nil
+	}
+	return &stats
+}
+
+// peakRSS returns the peak resident set size of a process in bytes, or -1
+// where `/proc` is not available.
+func peakRSS(pid int) int64 {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "VmHWM:") {
+			fields := strings.Fields(line)
+			if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return kb * 1024
+			}
+		}
+	}
+	return -1
+}
+
+// invocation is a request passed to a bootstrap process through the runtime
+// API.
+type invocation struct {
+	event    []byte
+	response chan []byte
+}
+
+// runtimeAPI is a stand-in for the Lambda Runtime API that the production
+// bootstrap polls for invocations.
+type runtimeAPI struct {
+	addr    string
+	queue   chan *invocation
+	ready   chan struct{}
+	mu      sync.Mutex
+	pending map[string]*invocation
+	nextID  int
+}
+
+func newRuntimeAPI() (*runtimeAPI, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	api := &runtimeAPI{
+		addr:    listener.Addr().String(),
+		queue:   make(chan *invocation),
+		ready:   make(chan struct{}, 1024),
+		pending: map[string]*invocation{},
+	}
+	go http.Serve(listener, api)
+	return api, nil
+}
+
+func (api *runtimeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/2018-06-01/runtime/"
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	switch {
+	case r.Method == "GET" && path == "invocation/next":
+		select {
+		case api.ready <- struct{}{}:
+		default:
+		}
+		inv := <-api.queue
+		api.mu.Lock()
+		api.nextID++
+		id := strconv.Itoa(api.nextID)
+		api.pending[id] = inv
+		api.mu.Unlock()
+
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", id)
+		w.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(time.Now().Add(time.Minute).UnixNano()/int64(time.Millisecond), 10))
+		w.Header().Set("Lambda-Runtime-Invoked-Function-Arn", "arn:aws:lambda:us-east-1:000000000000:function:load-test")
+		w.Header().Set("Lambda-Runtime-Trace-Id", "Root=1-00000000-000000000000000000000000")
+		w.Write(inv.event)
+
+	case r.Method == "POST" && strings.HasPrefix(path, "invocation/"):
+		parts := strings.Split(path, "/")
+		if len(parts) != 3 {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		api.mu.Lock()
+		inv := api.pending[parts[1]]
+		delete(api.pending, parts[1])
+		api.mu.Unlock()
+		if inv == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if parts[2] != "response" {
+			log.Printf("invocation error: %s", body)
+			body = nil
+		}
+		inv.response <- body
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.Method == "POST" && path == "init/error":
+		body, _ := ioutil.ReadAll(r.Body)
+		log.Fatalf("the bootstrap failed to initialize: %s", body)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// startBootstraps starts `n` bootstrap processes, like `n` concurrent
+// instances of the function, and waits until they poll for invocations.
+func (api *runtimeAPI) startBootstraps(bootstrap string, n int, verbose bool) ([]*exec.Cmd, error) {
+	bootstrap, err := filepath.Abs(bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	var procs []*exec.Cmd
+	for i := 0; i < n; i++ {
+		cmd := exec.Command(bootstrap)
+		cmd.Dir = filepath.Dir(bootstrap)
+		cmd.Env = append(os.Environ(),
+			"AWS_LAMBDA_RUNTIME_API="+api.addr,
+			"LAMBDA_TASK_ROOT="+cmd.Dir,
+			"_HANDLER="+filepath.Base(bootstrap),
+			"AWS_LAMBDA_FUNCTION_NAME=load-test",
+			"AWS_LAMBDA_FUNCTION_VERSION=$LATEST",
+			"AWS_LAMBDA_FUNCTION_MEMORY_SIZE=1024",
+			"AWS_REGION=us-east-1",
+		)
+		if verbose {
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+		}
+		if err := cmd.Start(); err != nil {
+			return procs, err
+		}
+		procs = append(procs, cmd)
+	}
+
+	timeout := time.After(30 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-api.ready:
+		case <-timeout:
+			return procs, fmt.Errorf("the bootstrap processes did not poll for invocations within 30s")
+		}
+	}
+	return procs, nil
+}
+
+// sender invokes the bootstrap with the request encoded like the platform
+// does for `github.com/vercel/go-bridge`.
+func (api *runtimeAPI) sender(tmpl requestTemplate) sender {
+	headers := map[string]string{"host": "localhost"}
+	for name, value := range tmpl.Headers {
+		headers[strings.ToLower(name)] = value
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"host":     "localhost",
+		"path":     tmpl.Path,
+		"method":   tmpl.Method,
+		"headers":  headers,
+		"encoding": "base64",
+		"body":     base64.StdEncoding.EncodeToString([]byte(tmpl.Body)),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	event, err := json.Marshal(map[string]string{"body": string(payload)})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return func() response {
+		inv := &invocation{event: event, response: make(chan []byte, 1)}
+		started := time.Now()
+		api.queue <- inv
+		body := <-inv.response
+		elapsed := time.Since(started)
+
+		var res struct {
+			StatusCode int `json:"statusCode"`
+		}
+		if body == nil || json.Unmarshal(body, &res) != nil {
+			return response{elapsed: elapsed}
+		}
+		return response{status: res.StatusCode, elapsed: elapsed}
+	}
+}
+
+func report(w io.Writer, res *result) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "Target        %s\n", res.Target)
+	fmt.Fprintf(&out, "Requests      %d in %.1fs with %d workers (%.1f/s)\n", res.Requests, res.Duration, res.Concurrency, res.Throughput)
+	fmt.Fprintf(&out, "Latency       p50 %.2fms, p90 %.2fms, p99 %.2fms, max %.2fms\n", res.Latency.P50, res.Latency.P90, res.Latency.P99, res.Latency.Max)
+	fmt.Fprintf(&out, "Errors        %d (%.2f%%)\n", res.Errors, res.ErrorRate*100)
+
+	codes := make([]string, 0, len(res.StatusCodes))
+	for code, count := range res.StatusCodes {
+		codes = append(codes, fmt.Sprintf("%s: %d", code, count))
+	}
+	sort.Strings(codes)
+	fmt.Fprintf(&out, "Status codes  %s\n", strings.Join(codes, ", "))
+
+	if res.AllocsPerRequest >= 0 {
+		fmt.Fprintf(&out, "Allocations   %.1f allocs/request, %.1f KB/request\n", res.AllocsPerRequest, res.BytesPerRequest/1024)
+	} else {
+		fmt.Fprintf(&out, "Allocations   n/a\n")
+	}
+	if res.PeakRSS >= 0 {
+		fmt.Fprintf(&out, "Peak RSS      %.1f MB\n", float64(res.PeakRSS)/(1<<20))
+	} else {
+		fmt.Fprintf(&out, "Peak RSS      n/a\n")
+	}
+	w.Write(out.Bytes())
+}
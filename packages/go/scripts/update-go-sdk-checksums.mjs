@@ -0,0 +1,56 @@
+// Writes the SHA-256 checksums of the Go SDK archives of the versions in the
+// version map of `src/go-helpers.ts` to `go-sdk-checksums.json`, which
+// `download()` verifies the archives against.
+//
+// Usage: node scripts/update-go-sdk-checksums.mjs
+
+import { readFile, writeFile } from 'node:fs/promises';
+import { fileURLToPath } from 'node:url';
+
+const root = new URL('..', import.meta.url);
+const platforms = new Set([
+  'darwin-amd64',
+  'darwin-arm64',
+  'linux-386',
+  'linux-amd64',
+  'linux-arm64',
+  'windows-386',
+  'windows-amd64',
+  'windows-arm64',
+]);
+
+const helpers = await readFile(new URL('src/go-helpers.ts', root), 'utf8');
+const versions = new Set(
+  Array.from(
+    helpers.matchAll(/\['\d+\.\d+', '(\d+\.\d+\.\d+)'\]/g),
+    ([, version]) => `go${version}`
+  )
+);
+
+const res = await fetch('https://go.dev/dl/?mode=json&include=all');
+if (!res.ok) {
+  throw new Error(`Failed to fetch the Go releases (${res.status})`);
+}
+
+const checksums = {};
+for (const release of await res.json()) {
+  if (!versions.has(release.version)) {
+    continue;
+  }
+  versions.delete(release.version);
+  for (const file of release.files) {
+    if (file.kind === 'archive' && platforms.has(`${file.os}-${file.arch}`)) {
+      checksums[file.filename] = file.sha256;
+    }
+  }
+}
+if (versions.size > 0) {
+  throw new Error(`No releases found for ${Array.from(versions).join(', ')}`);
+}
+
+const sorted = Object.fromEntries(
+  Object.entries(checksums).sort(([a], [b]) => a.localeCompare(b))
+);
+const dest = fileURLToPath(new URL('go-sdk-checksums.json', root));
+await writeFile(dest, `${JSON.stringify(sorted, null, 2)}\n`);
+console.log(`Wrote ${Object.keys(sorted).length} checksums to ${dest}`);
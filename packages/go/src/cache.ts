@@ -0,0 +1,411 @@
+import { createHash } from 'crypto';
+import {
+  chmod,
+  copy,
+  lstat,
+  move,
+  pathExists,
+  readdir,
+  readFile,
+  remove,
+  utimes,
+} from 'fs-extra';
+import { dirname, extname, join, relative, sep } from 'path';
+import { debug, streamToBuffer } from '@vercel/build-utils';
+import type { Env, Files } from '@vercel/build-utils';
+import type { BuildChecks } from './checks';
+import { parseGoWorkUse } from './go-helpers';
+
+// The maximum size in bytes of each of the Go build, module and output
+// caches that `prepareCache` persists
+export const GO_CACHE_MAX_SIZE = 1024 * 1024 * 1024;
+
+// The outputs of `build()` by the key of their inputs, see
+// `getOutputCacheKey()`
+export const localOutputCacheDir = join('.vercel', 'cache', 'go-output');
+
+// Environment variables that change the output of `go build`
+const outputCacheEnv = [
+  'CGO_ENABLED',
+  'GO_BUILD_FLAGS',
+  'GOAMD64',
+  'GOARCH',
+  'GOARM',
+  'GOEXPERIMENT',
+  'GOFLAGS',
+  'GOOS',
+];
+
+// Files of the Go build cache that are not cache entries and must be kept
+const buildCacheMetaFiles = new Set(['README', 'testexpire.txt', 'trim.txt']);
+
+interface CacheEntry {
+  /**
+   * The files and directories to remove when the entry is pruned
+   */
+  paths: Set<string>;
+  size: number;
+  mtime: number;
+}
+
+interface CacheFile {
+  path: string;
+  size: number;
+  mtime: number;
+}
+
+async function walk(dir: string, files: CacheFile[]): Promise<CacheFile[]> {
+  let names: string[];
+  try {
+    names = await readdir(dir);
+  } catch (err: any) {
+    // pruned by a parallel build
+    if (err.code === 'ENOENT') {
+      return files;
+    }
+    throw err;
+  }
+  for (const name of names) {
+    const path = join(dir, name);
+    const stat = await lstat(path).catch(() => undefined);
+    if (!stat) {
+      continue;
+    }
+    if (stat.isDirectory()) {
+      await walk(path, files);
+    } else {
+      files.push({ path, size: stat.size, mtime: stat.mtimeMs });
+    }
+  }
+  return files;
+}
+
+/**
+ * Maps a file of the Go build cache (`GOCACHE`) to the cache entry it belongs
+ * to. Every output and action file is an entry, and Go updates their mtime
+ * when they are used.
+ */
+export function getBuildCacheEntry(file: string): string | undefined {
+  return buildCacheMetaFiles.has(file) ? undefined : file;
+}
+
+/**
+ * Maps a file of the Go module cache (`GOMODCACHE`) to the cache entry it
+ * belongs to, so that a module is pruned as a whole: the extracted
+ * `module@version` directory, or the `.info`, `.mod`, `.zip` and `.ziphash`
+ * files of a version in the download cache.
+ */
+export function getModCacheEntry(file: string): string | undefined {
+  const parts = file.split(sep);
+  if (parts[0] === 'cache') {
+    if (parts[parts.length - 2] === '@v') {
+      const version = parts[parts.length - 1].replace(
+        /\.(info|lock|mod|zip|ziphash|partial|tmp)$/,
+        ''
+      );
+      return join(dirname(file), version);
+    }
+    return file;
+  }
+  const index = parts.findIndex(part => part.includes('@'));
+  return index === -1 ? file : parts.slice(0, index + 1).join(sep);
+}
+
+/**
+ * Maps a file of the output cache to the output it belongs to, so that the
+ * outputs of least recently built entrypoints are pruned as a whole.
+ */
+export function getOutputCacheEntry(file: string): string | undefined {
+  return file.split(sep)[0];
+}
+
+/**
+ * Makes a path of the module cache writable, which Go creates read-only.
+ */
+async function makeWritable(path: string, recursive: boolean): Promise<void> {
+  const stat = await lstat(path).catch(() => undefined);
+  if (!stat || stat.isSymbolicLink()) {
+    return;
+  }
+  await chmod(path, stat.mode | 0o200);
+  if (recursive && stat.isDirectory()) {
+    for (const name of await readdir(path)) {
+      await makeWritable(join(path, name), true);
+    }
+  }
+}
+
+/**
+ * Removes the least recently modified entries of a cache directory until its
+ * size is at most `maxSize` bytes.
+ *
+ * @param dir The cache directory
+ * @param maxSize The maximum size in bytes
+ * @param getEntry Maps the path of a file relative to `dir` to the entry it
+ * belongs to, or `undefined` if the file must be kept
+ * @returns The number of bytes removed
+ */
+export async function pruneCache(
+  dir: string,
+  maxSize: number,
+  getEntry: (file: string) => string | undefined
+): Promise<number> {
+  const files = await walk(dir, []);
+  let size = files.reduce((total, file) => total + file.size, 0);
+  if (size <= maxSize) {
+    debug(`Go cache ${dir} is ${size} bytes, not pruning`);
+    return 0;
+  }
+
+  const entries = new Map<string, CacheEntry>();
+  for (const file of files) {
+    const rel = relative(dir, file.path);
+    const name = getEntry(rel);
+    if (!name) {
+      continue;
+    }
+    let entry = entries.get(name);
+    if (!entry) {
+      entry = { paths: new Set(), size: 0, mtime: 0 };
+      entries.set(name, entry);
+    }
+    // an entry that is a directory is removed as a whole
+    entry.paths.add(rel.startsWith(name + sep) ? join(dir, name) : file.path);
+    entry.size += file.size;
+    entry.mtime = Math.max(entry.mtime, file.mtime);
+  }
+
+  let removed = 0;
+  const sorted = Array.from(entries.values()).sort((a, b) => a.mtime - b.mtime);
+  for (const entry of sorted) {
+    if (size <= maxSize) {
+      break;
+    }
+    for (const path of entry.paths) {
+      await makeWritable(dirname(path), false);
+      await makeWritable(path, true);
+      await remove(path);
+    }
+    size -= entry.size;
+    removed += entry.size;
+  }
+  debug(`Pruned ${removed} bytes of Go cache ${dir}`);
+  return removed;
+}
+
+export interface OutputCacheKeyOptions {
+  /**
+   * The directory that the paths of `files` are keyed relative to
+   */
+  workPath: string;
+  /**
+   * The source files the output is built from, e.g. those of the import
+   * graph of the entrypoint
+   */
+  files: string[];
+  /**
+   * The `go.work` of the module, if any
+   */
+  goWorkPath?: string;
+  /**
+   * The directory of the `go.mod`, since the dependencies of an entrypoint
+   * without one are not pinned and cannot be keyed
+   */
+  modulePath: string;
+  goVersion: string;
+  env: Env;
+  includedFiles: Files;
+  runtime: string;
+  pgo?: string;
+  /**
+   * The quality gates that passed before the output was built
+   */
+  checks?: BuildChecks;
+  /**
+   * Whether `go generate` runs before the build, in which case `files` must
+   * have every file that the generators may read
+   */
+  generate?: boolean;
+}
+
+// The extensions of the files `go build` reads from a package directory
+// besides its Go files, like assembly, cgo sources and `.syso` objects
+const packageSourceExtensions = [
+  '.c',
+  '.cc',
+  '.cpp',
+  '.cxx',
+  '.f',
+  '.F',
+  '.f90',
+  '.for',
+  '.h',
+  '.hh',
+  '.hpp',
+  '.hxx',
+  '.m',
+  '.s',
+  '.S',
+  '.sx',
+  '.swig',
+  '.swigcxx',
+  '.syso',
+];
+
+/**
+ * Computes a key of everything that affects the output of `build()` for an
+ * entrypoint, so that an unchanged entrypoint is not built again.
+ */
+export async function getOutputCacheKey({
+  workPath,
+  files,
+  goWorkPath,
+  modulePath,
+  goVersion,
+  env,
+  includedFiles,
+  runtime,
+  pgo,
+  checks,
+  generate,
+}: OutputCacheKeyOptions): Promise<string> {
+  const hash = createHash('sha256');
+  const add = (name: string, value: string | Buffer) => {
+    hash.update(`${name}\0`);
+    hash.update(value);
+    hash.update('\0');
+  };
+  const addFile = async (name: string, path: string) => {
+    if (await pathExists(path)) {
+      add(name, await readFile(path));
+    }
+  };
+
+  // the builder itself, since its `main.go` wraps the handler
+  await addFile('package.json', join(__dirname, '../package.json'));
+  await addFile('main.go', join(__dirname, '../main.go'));
+
+  for (const file of [...files].sort()) {
+    await addFile(`file:${relative(workPath, file)}`, file);
+  }
+  // the other sources of the packages, which `files` may not list, like
+  // assembly and cgo files, or Go files excluded by build constraints
+  const packageDirs = new Set(
+    files.filter(file => file.endsWith('.go')).map(file => dirname(file))
+  );
+  for (const dir of Array.from(packageDirs).sort()) {
+    const names = await readdir(dir).catch(() => [] as string[]);
+    for (const name of names.sort()) {
+      const file = join(dir, name);
+      if (
+        !files.includes(file) &&
+        !name.endsWith('_test.go') &&
+        (name.endsWith('.go') ||
+          packageSourceExtensions.includes(extname(name))) &&
+        (await lstat(file)).isFile()
+      ) {
+        await addFile(`source:${relative(workPath, file)}`, file);
+      }
+    }
+  }
+  for (const name of ['go.mod', 'go.sum', join('vendor', 'modules.txt')]) {
+    await addFile(`module:${name}`, join(modulePath, name));
+  }
+  if (goWorkPath) {
+    const goWorkDir = dirname(goWorkPath);
+    await addFile('work:go.work', goWorkPath);
+    await addFile('work:go.work.sum', `${goWorkPath}.sum`);
+    await addFile('work:vendor', join(goWorkDir, 'vendor', 'modules.txt'));
+    const contents = await readFile(goWorkPath, 'utf8');
+    for (const use of parseGoWorkUse(contents)) {
+      for (const name of ['go.mod', 'go.sum']) {
+        await addFile(`use:${use}:${name}`, join(goWorkDir, use, name));
+      }
+    }
+  }
+  if (generate) {
+    add('generate', 'true');
+  }
+  for (const name of Object.keys(includedFiles).sort()) {
+    add(
+      `include:${name}`,
+      await streamToBuffer(includedFiles[name].toStream())
+    );
+  }
+  if (pgo) {
+    await addFile('pgo', pgo);
+  }
+  if (checks && (checks.vet || checks.test)) {
+    add('checks', JSON.stringify(checks));
+    // `go vet` and `go test` also read the tests of the packages, and the
+    // tests read their `testdata`
+    const dirs = new Set(files.map(file => dirname(file)));
+    for (const dir of Array.from(dirs).sort()) {
+      const names = await readdir(dir).catch(() => [] as string[]);
+      for (const name of names.sort()) {
+        if (name.endsWith('_test.go')) {
+          const file = join(dir, name);
+          await addFile(`test:${relative(workPath, file)}`, file);
+        }
+      }
+      if (checks.test) {
+        const testdata = await walk(join(dir, 'testdata'), []);
+        const paths = testdata.map(file => file.path).sort();
+        for (const path of paths) {
+          await addFile(`testdata:${relative(workPath, path)}`, path);
+        }
+      }
+    }
+  }
+
+  add('go', goVersion);
+  add('runtime', runtime);
+  for (const name of outputCacheEnv) {
+    add(`env:${name}`, env[name] || '');
+  }
+
+  return hash.digest('hex').substring(0, 32);
+}
+
+/**
+ * Copies a cached output to `outDir`, if any.
+ *
+ * @returns Whether the output was cached
+ */
+export async function restoreCachedOutput(
+  cacheDir: string,
+  outDir: string
+): Promise<boolean> {
+  if (!(await pathExists(cacheDir))) {
+    return false;
+  }
+  await copy(cacheDir, outDir);
+
+  // mark the output as recently used for pruning
+  const now = new Date();
+  for (const name of await readdir(cacheDir)) {
+    await utimes(join(cacheDir, name), now, now);
+  }
+  return true;
+}
+
+/**
+ * Copies an output to the cache. Parallel builds of the same entrypoint may
+ * save the same output, so it is copied next to `cacheDir` and moved in place.
+ */
+export async function saveCachedOutput(
+  outDir: string,
+  cacheDir: string
+): Promise<void> {
+  const tmp = `${cacheDir}.${process.pid}.tmp`;
+  try {
+    await copy(outDir, tmp);
+    if (!(await pathExists(cacheDir))) {
+      await move(tmp, cacheDir);
+    }
+  } catch (err) {
+    debug(`Failed to cache Go output to ${cacheDir}: ${err}`);
+  } finally {
+    await remove(tmp);
+  }
+}
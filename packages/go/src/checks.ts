@@ -0,0 +1,227 @@
+import stringArgv from 'string-argv';
+import type { Config, Env } from '@vercel/build-utils';
+import type { GoWrapper } from './go-helpers';
+
+/**
+ * The quality gates that must pass before the handler is built, enabled with
+ * the `goVet` and `goTest` config.
+ */
+export interface BuildChecks {
+  /**
+   * Whether `go vet` must not report issues
+   */
+  vet: boolean;
+  /**
+   * Whether the tests must pass
+   */
+  test: boolean;
+}
+
+/**
+ * Reads an opt-in of the function config, which defaults to `false`.
+ */
+export function getBooleanConfig(config: Config, name: string): boolean {
+  const value = config[name];
+  if (value === undefined || value === null) {
+    return false;
+  }
+  if (typeof value !== 'boolean') {
+    throw new Error(
+      `Invalid \`${name}\` config ${JSON.stringify(value)}, expected a boolean`
+    );
+  }
+  return value;
+}
+
+/**
+ * Reads the quality gates of the build from the function config.
+ */
+export function getBuildChecks(config: Config = {}): BuildChecks {
+  return {
+    vet: getBooleanConfig(config, 'goVet'),
+    test: getBooleanConfig(config, 'goTest'),
+  };
+}
+
+/**
+ * Returns the `-tags` flags of `GO_BUILD_FLAGS`, so that the checks see the
+ * same files as `go build`. Other flags, like `-ldflags`, do not apply.
+ */
+export function getBuildTagFlags(env: Env): string[] {
+  const args = stringArgv(env.GO_BUILD_FLAGS || '');
+  const flags: string[] = [];
+  for (let i = 0; i < args.length; i++) {
+    if (/^--?tags=/.test(args[i])) {
+      flags.push(args[i]);
+    } else if (/^--?tags$/.test(args[i]) && i + 1 < args.length) {
+      flags.push(args[i], args[i + 1]);
+      i++;
+    }
+  }
+  return flags;
+}
+
+export interface TestSummary {
+  passed: number;
+  failed: number;
+  skipped: number;
+  /**
+   * The packages whose tests failed, or failed to build
+   */
+  failedPackages: string[];
+  /**
+   * The output of the failed tests and of the failed builds
+   */
+  output: string;
+}
+
+interface TestEvent {
+  Action: string;
+  Package?: string;
+  ImportPath?: string;
+  Test?: string;
+  Output?: string;
+}
+
+/**
+ * Summarizes the output of `go test -json`. The output of a test is only kept
+ * if it fails, and lines that are not JSON are kept as is.
+ */
+export function summarizeTestEvents(stdout: string): TestSummary {
+  const summary: TestSummary = {
+    passed: 0,
+    failed: 0,
+    skipped: 0,
+    failedPackages: [],
+    output: '',
+  };
+  // the output of each running test, package and package build
+  const outputs = new Map<string, string>();
+  const append = (key: string, output = '') => {
+    outputs.set(key, (outputs.get(key) || '') + output);
+  };
+  const flush = (key: string) => {
+    summary.output += outputs.get(key) || '';
+    outputs.delete(key);
+  };
+
+  for (const line of stdout.split(/\r?\n/)) {
+    if (!line) {
+      continue;
+    }
+    let event: TestEvent;
+    try {
+      event = JSON.parse(line);
+    } catch {
+      summary.output += `${line}\n`;
+      continue;
+    }
+
+    // failed builds of test binaries are reported by import path since
+    // go 1.24, and on `stderr` before
+    if (event.ImportPath) {
+      const key = `build\0${event.ImportPath}`;
+      if (event.Action === 'build-output') {
+        append(key, event.Output);
+      } else if (event.Action === 'build-fail') {
+        flush(key);
+      }
+      continue;
+    }
+
+    const key = event.Test
+      ? `test\0${event.Package}\0${event.Test}`
+      : `package\0${event.Package}`;
+    switch (event.Action) {
+      case 'output':
+        append(key, event.Output);
+        break;
+      case 'pass':
+        if (event.Test) {
+          summary.passed++;
+        }
+        outputs.delete(key);
+        break;
+      case 'skip':
+        if (event.Test) {
+          summary.skipped++;
+        }
+        outputs.delete(key);
+        break;
+      case 'fail':
+        if (event.Test) {
+          summary.failed++;
+        } else if (event.Package) {
+          summary.failedPackages.push(event.Package);
+        }
+        flush(key);
+        break;
+    }
+  }
+  return summary;
+}
+
+/**
+ * Counts the issues in the output of `go vet`, one per `file.go:line:col:`
+ * line.
+ */
+export function countVetIssues(output: string): number {
+  const lines = output.split(/\r?\n/);
+  return lines.filter(line => /\.go:\d+(:\d+)?: /.test(line)).length;
+}
+
+function plural(n: number, word: string) {
+  return `${n} ${word}${n === 1 ? '' : 's'}`;
+}
+
+/**
+ * Runs the enabled quality gates on the package of the entrypoint and the
+ * local packages it depends on, with the build tags of `GO_BUILD_FLAGS`.
+ * A summary is printed to the build log.
+ *
+ * @param go A `GoWrapper` in the directory of the entrypoint
+ * @param checks The enabled checks
+ * @param env The build environment, for `GO_BUILD_FLAGS`
+ * @throws If `go vet` reports issues or a test fails
+ */
+export async function runBuildChecks(
+  go: GoWrapper,
+  checks: BuildChecks,
+  env: Env
+): Promise<void> {
+  const flags = getBuildTagFlags(env);
+  const packages = await go.listLocalPackages(['.'], flags);
+
+  if (checks.vet) {
+    console.log(`Running \`go vet\` on ${plural(packages.length, 'package')}`);
+    const { code, stderr, stdout } = await go.vet(packages, flags);
+    if (code !== 0) {
+      const output = `${stdout}${stderr}`;
+      console.error(output);
+      const issues = countVetIssues(output);
+      throw new Error(
+        issues > 0
+          ? `\`go vet\` reported ${plural(issues, 'issue')}`
+          : `\`go vet\` failed with exit code ${code}`
+      );
+    }
+    console.log('`go vet` passed');
+  }
+
+  if (checks.test) {
+    console.log(`Running \`go test\` on ${plural(packages.length, 'package')}`);
+    const { code, stderr, stdout } = await go.test(packages, flags);
+    const summary = summarizeTestEvents(stdout);
+    const counts = `${summary.passed} passed, ${summary.failed} failed, ${summary.skipped} skipped`;
+    if (code !== 0) {
+      console.error(`${summary.output}${stderr}`);
+      const failed = summary.failedPackages;
+      throw new Error(
+        failed.length > 0
+          ? `\`go test\` failed in ${failed.join(', ')} (${counts})`
+          : `\`go test\` failed with exit code ${code} (${counts})`
+      );
+    }
+    console.log(`\`go test\` passed (${counts})`);
+  }
+}
@@ -0,0 +1,213 @@
+import { mkdirp, remove, readFile, readFileSync, writeFile } from 'fs-extra';
+import { dirname, isAbsolute, join, relative } from 'path';
+import { debug } from '@vercel/build-utils';
+import type { GoWrapper } from './go-helpers';
+
+// A block of a Go coverage profile, e.g.:
+// `example.com/pkg/file.go:10.2,12.16 2 1`
+const blockRegExp = /^(.+\.go):(\d+\.\d+,\d+\.\d+) (\d+) (\d+)$/;
+
+export interface CoverageSummary {
+  file: string;
+  statements: number;
+  covered: number;
+}
+
+/**
+ * Rewrites the file names of a Go coverage profile, which are import paths,
+ * using the `resolve` function. Blocks of files that `resolve` returns
+ * `undefined` for are dropped.
+ *
+ * @param profile The contents of the coverage profile
+ * @param resolve Maps an import path file name to a source file
+ * @returns The rewritten coverage profile
+ */
+export function mapCoverageProfile(
+  profile: string,
+  resolve: (file: string) => string | undefined
+): string {
+  const lines: string[] = [];
+  for (const line of profile.split(/\r?\n/)) {
+    const matches = blockRegExp.exec(line);
+    if (!matches) {
+      if (line.startsWith('mode:')) {
+        lines.push(line);
+      }
+      continue;
+    }
+    const file = resolve(matches[1]);
+    if (file) {
+      lines.push(`${file}:${line.substring(matches[1].length + 1)}`);
+    }
+  }
+  return `${lines.join('\n')}\n`;
+}
+
+/**
+ * Computes the statement coverage of each file in a Go coverage profile.
+ * Blocks that appear multiple times, as happens when the profile merges the
+ * counters of several runs, are only counted once.
+ *
+ * @param profile The contents of the coverage profile
+ * @returns The coverage of each file, sorted by file name
+ */
+export function summarizeCoverageProfile(profile: string): CoverageSummary[] {
+  const blocks = new Map<string, { statements: number; hit: boolean }>();
+  for (const line of profile.split(/\r?\n/)) {
+    const matches = blockRegExp.exec(line);
+    if (!matches) {
+      continue;
+    }
+    const [, file, range, statements, count] = matches;
+    const key = `${file}:${range}`;
+    const hit = Number(count) > 0 || Boolean(blocks.get(key)?.hit);
+    blocks.set(key, { statements: Number(statements), hit });
+  }
+
+  const files = new Map<string, CoverageSummary>();
+  for (const [key, { statements, hit }] of blocks) {
+    const file = key.substring(0, key.lastIndexOf(':'));
+    let summary = files.get(file);
+    if (!summary) {
+      summary = { file, statements: 0, covered: 0 };
+      files.set(file, summary);
+    }
+    summary.statements += statements;
+    if (hit) {
+      summary.covered += statements;
+    }
+  }
+
+  return Array.from(files.values()).sort((a, b) =>
+    a.file.localeCompare(b.file)
+  );
+}
+
+function isWithin(dir: string, fsPath: string) {
+  const rel = relative(dir, fsPath);
+  return !rel.startsWith('..') && !isAbsolute(rel);
+}
+
+function percent(covered: number, statements: number) {
+  return statements ? (covered / statements) * 100 : 0;
+}
+
+/**
+ * Formats the coverage summaries as a table, including the total.
+ */
+export function formatCoverageSummary(summaries: CoverageSummary[]): string {
+  let statements = 0;
+  let covered = 0;
+  const width = Math.max(5, ...summaries.map(s => s.file.length));
+  const lines = summaries.map(s => {
+    statements += s.statements;
+    covered += s.covered;
+    return `${s.file.padEnd(width)}  ${percent(s.covered, s.statements)
+      .toFixed(1)
+      .padStart(5)}%`;
+  });
+  lines.push(
+    `${'total'.padEnd(width)}  ${percent(covered, statements)
+      .toFixed(1)
+      .padStart(5)}%`
+  );
+  return lines.join('\n');
+}
+
+export interface CoverageProfileOptions {
+  /**
+   * The absolute path to the original entrypoint
+   */
+  entrypoint: string;
+  /**
+   * The `go` wrapper the dev server was built with
+   */
+  go: GoWrapper;
+  /**
+   * The `GOCOVERDIR` the dev server wrote its coverage data to
+   */
+  dataDir: string;
+  /**
+   * The path to write the coverage profile to
+   */
+  profilePath: string;
+  /**
+   * The dev server's tmp directory, containing its generated sources
+   */
+  tmp: string;
+  /**
+   * The directory containing the copy of the entrypoint (`entrypoint.go`)
+   */
+  tmpPackage: string;
+  workPath: string;
+}
+
+/**
+ * Converts the binary coverage data of the dev server to a text coverage
+ * profile whose file names point to the original source files rather than
+ * the generated copies in the tmp directory.
+ */
+export async function writeCoverageProfile({
+  entrypoint,
+  go,
+  dataDir,
+  profilePath,
+  tmp,
+  tmpPackage,
+  workPath,
+}: CoverageProfileOptions): Promise<void> {
+  const modules = await go.listModules();
+
+  // longest module path first so that nested modules take precedence
+  modules.sort((a, b) => b.path.length - a.path.length);
+
+  const tmpEntrypoint = join(tmpPackage, 'entrypoint.go');
+  const resolve = (file: string) => {
+    const mod = modules.find(
+      m => file === m.path || file.startsWith(`${m.path}/`)
+    );
+    if (!mod || !mod.dir) {
+      return undefined;
+    }
+    const fsPath = join(mod.dir, file.substring(mod.path.length + 1));
+    if (fsPath === tmpEntrypoint) {
+      return relative(workPath, entrypoint);
+    }
+    if (isWithin(tmp, fsPath)) {
+      // the generated dev server sources
+      return undefined;
+    }
+    return isWithin(workPath, fsPath) ? relative(workPath, fsPath) : fsPath;
+  };
+
+  const rawProfilePath = join(tmp, 'coverage.raw.out');
+  await go.coverageProfile(dataDir, rawProfilePath);
+  const profile = mapCoverageProfile(
+    await readFile(rawProfilePath, 'utf8'),
+    resolve
+  );
+  await remove(rawProfilePath);
+
+  debug(`Writing coverage profile ${profilePath}`);
+  await mkdirp(dirname(profilePath));
+  await writeFile(profilePath, profile);
+}
+
+/**
+ * Prints a summary of the coverage profile of an entrypoint. It reads the
+ * profile synchronously, so that it can run when `vercel dev` exits.
+ */
+export function printCoverageReport(
+  entrypoint: string,
+  profilePath: string,
+  workPath: string
+): void {
+  const profile = readFileSync(profilePath, 'utf8');
+  const summary = formatCoverageSummary(summarizeCoverageProfile(profile));
+  console.log(
+    `Coverage of "${relative(workPath, entrypoint)}" (${relative(
+      workPath,
+      profilePath
+    )}):\n${summary}`
+  );
+}
@@ -0,0 +1,224 @@
+import { join } from 'path';
+import type { Config, Env } from '@vercel/build-utils';
+
+// Default address Delve listens on when `VERCEL_DEV_GO_DEBUG_PORT` is not set
+const DEFAULT_DEBUG_PORT = 2345;
+
+// Default `maxDuration` in seconds enforced in strict mode, when the function
+// does not configure one
+const DEFAULT_MAX_DURATION = 10;
+
+// Default time in milliseconds that goroutines started by a request may keep
+// running after its response before they are reported as leaked
+const DEFAULT_LEAK_GRACE = 1000;
+
+export interface DebugOptions {
+  /**
+   * The address Delve listens on for a debugger to attach
+   */
+  address: string;
+  /**
+   * Whether the dev server should wait for a debugger to attach before it
+   * starts serving requests
+   */
+  wait: boolean;
+}
+
+export interface RaceOptions {
+  /**
+   * Whether a summary of the races detected while handling a request is
+   * returned in the `X-Vercel-Go-Race` response header
+   */
+  header: boolean;
+}
+
+export interface CoverOptions {
+  /**
+   * The directory to write coverage data and profiles to, relative to the
+   * work path unless absolute
+   */
+  dir: string;
+}
+
+export interface StrictOptions {
+  /**
+   * The maximum duration of a request in seconds
+   */
+  maxDuration: number;
+}
+
+export interface PlatformHeadersOptions {
+  /**
+   * A JSON file of header names to values that override the emulated platform
+   * headers, relative to the work path unless absolute
+   */
+  file?: string;
+}
+
+export interface RecordOptions {
+  /**
+   * The HAR file to record requests and responses to, relative to the work
+   * path unless absolute. Defaults to `testdata/<name>.har` next to the
+   * entrypoint.
+   */
+  file?: string;
+}
+
+export interface LeakOptions {
+  /**
+   * How long in milliseconds goroutines started by a request may keep running
+   * after its response
+   */
+  grace: number;
+}
+
+export type CassetteMode = 'auto' | 'record' | 'replay';
+
+const CASSETTE_MODES: CassetteMode[] = ['auto', 'record', 'replay'];
+
+export interface CassetteOptions {
+  /**
+   * The directory of the cassette files, relative to the work path unless
+   * absolute
+   */
+  dir: string;
+  /**
+   * Whether outbound requests without a cassette are recorded (`auto`), all
+   * outbound requests are recorded again (`record`), or requests without a
+   * cassette fail (`replay`)
+   */
+  mode: CassetteMode;
+}
+
+/**
+ * Opt-in `vercel dev` features of the Go dev server.
+ */
+export interface DevOptions {
+  debug?: DebugOptions;
+  race?: RaceOptions;
+  cover?: CoverOptions;
+  strict?: StrictOptions;
+  platformHeaders?: PlatformHeadersOptions;
+  record?: RecordOptions;
+  cassettes?: CassetteOptions;
+  /**
+   * Whether the dev server is frozen after each response, like an instance
+   * between invocations, and kept running for the next requests until the
+   * sources change
+   */
+  freeze?: boolean;
+  /**
+   * Whether every request is handled by a new process of the dev server
+   */
+  coldStart?: boolean;
+  leaks?: LeakOptions;
+  /**
+   * Whether the CPU profiles of the dev server are merged into the
+   * `default.pgo` of the entrypoint for profile-guided optimization
+   */
+  pgo?: boolean;
+}
+
+/**
+ * Returns `true` if the environment variable is set to an affirmative value.
+ */
+export function isEnabled(value?: string): boolean {
+  return value === '1' || value === 'true';
+}
+
+/**
+ * Reads the Go dev server options from the `VERCEL_DEV_GO_*` environment
+ * variables.
+ *
+ * @param env The environment of the dev server
+ * @param config The function config
+ * @returns The enabled dev server features
+ */
+export function getDevOptions(env: Env, config: Config = {}): DevOptions {
+  const options: DevOptions = {};
+
+  if (isEnabled(env.VERCEL_DEV_GO_DEBUG)) {
+    const port = Number(env.VERCEL_DEV_GO_DEBUG_PORT) || DEFAULT_DEBUG_PORT;
+    options.debug = {
+      address: `127.0.0.1:${port}`,
+      wait: isEnabled(env.VERCEL_DEV_GO_DEBUG_WAIT),
+    };
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_RACE)) {
+    options.race = {
+      header: isEnabled(env.VERCEL_DEV_GO_RACE_HEADER),
+    };
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_COVER)) {
+    options.cover = {
+      dir: env.VERCEL_DEV_GO_COVER_DIR || join('.vercel', 'go-coverage'),
+    };
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_STRICT)) {
+    options.strict = {
+      maxDuration:
+        typeof config.maxDuration === 'number'
+          ? config.maxDuration
+          : DEFAULT_MAX_DURATION,
+    };
+  }
+
+  if (
+    isEnabled(env.VERCEL_DEV_GO_PLATFORM_HEADERS) ||
+    env.VERCEL_DEV_GO_PLATFORM_HEADERS_FILE
+  ) {
+    options.platformHeaders = {
+      file: env.VERCEL_DEV_GO_PLATFORM_HEADERS_FILE || undefined,
+    };
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_RECORD) || env.VERCEL_DEV_GO_RECORD_FILE) {
+    options.record = {
+      file: env.VERCEL_DEV_GO_RECORD_FILE || undefined,
+    };
+  }
+
+  if (
+    isEnabled(env.VERCEL_DEV_GO_CASSETTES) ||
+    env.VERCEL_DEV_GO_CASSETTE_DIR
+  ) {
+    const mode = (env.VERCEL_DEV_GO_CASSETTE_MODE || 'auto') as CassetteMode;
+    if (!CASSETTE_MODES.includes(mode)) {
+      const modes = CASSETTE_MODES.join(', ');
+      throw new Error(
+        `Invalid VERCEL_DEV_GO_CASSETTE_MODE "${mode}", expected one of: ${modes}`
+      );
+    }
+    options.cassettes = {
+      dir: env.VERCEL_DEV_GO_CASSETTE_DIR || join('.vercel', 'go-cassettes'),
+      mode,
+    };
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_FREEZE)) {
+    options.freeze = true;
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_COLD_START)) {
+    options.coldStart = true;
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_LEAKS)) {
+    const grace = Number(env.VERCEL_DEV_GO_LEAK_GRACE);
+    options.leaks = {
+      grace:
+        env.VERCEL_DEV_GO_LEAK_GRACE && Number.isInteger(grace) && grace >= 0
+          ? grace
+          : DEFAULT_LEAK_GRACE,
+    };
+  }
+
+  if (isEnabled(env.VERCEL_DEV_GO_PGO)) {
+    options.pgo = true;
+  }
+
+  return options;
+}
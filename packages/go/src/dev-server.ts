@@ -0,0 +1,1024 @@
+import retry from 'async-retry';
+import { tmpdir } from 'os';
+import { spawn } from 'child_process';
+import { createServer } from 'net';
+import { Readable } from 'stream';
+import once from '@tootallnate/once';
+import {
+  basename,
+  dirname,
+  isAbsolute,
+  join,
+  posix,
+  relative,
+  sep,
+} from 'path';
+import {
+  readFile,
+  writeFile,
+  lstat,
+  pathExists,
+  pathExistsSync,
+  mkdirp,
+  move,
+  remove,
+  readdir,
+  chmod,
+} from 'fs-extra';
+import {
+  StartDevServerOptions,
+  StartDevServerResult,
+  download,
+  debug,
+  cloneEnv,
+} from '@vercel/build-utils';
+import type { Env } from '@vercel/build-utils';
+
+const TMP = tmpdir();
+
+import {
+  createGo,
+  findGoModPath,
+  findGoWorkFile,
+  getAnalyzedEntrypoint,
+  getIncludedFiles,
+  GoWrapper,
+  lookPath,
+  parseGoWorkUse,
+  writeGoMod,
+  PGO_FILENAME,
+} from './go-helpers';
+import { getDevOptions } from './dev-options';
+import { printCoverageReport, writeCoverageProfile } from './coverage';
+import type { CoverageProfileOptions } from './coverage';
+import { getGenerateInputs } from './generate';
+
+// Exit code of `dev-server.go` when in-flight requests did not complete
+// before the drain timeout elapsed (see `vcExitDrainTimeout`)
+const DEV_SERVER_EXIT_DRAIN_TIMEOUT = 3;
+
+// Default number of seconds `dev-server.go` waits for in-flight requests
+// to complete when shutting down (see `vcDefaultDrainTimeout`)
+const DEV_SERVER_DRAIN_TIMEOUT = 5;
+
+// Additional time given to the dev server to run its shutdown hooks and exit
+// before it is forcefully killed
+const DEV_SERVER_SHUTDOWN_GRACE_MS = 5000;
+
+interface PortInfo {
+  port: number;
+}
+
+function isPortInfo(v: any): v is PortInfo {
+  return v && typeof v.port === 'number';
+}
+
+function isReadable(v: any): v is Readable {
+  return v && v.readable === true;
+}
+
+async function copyEntrypoint(entrypoint: string, dest: string): Promise<void> {
+  const data = await readFile(entrypoint, 'utf8');
+
+  // Modify package to `package main`
+  const patched = data.replace(/\bpackage\W+\S+\b/, 'package main');
+
+  await writeFile(join(dest, 'entrypoint.go'), patched);
+}
+
+async function copyDevServer(
+  functionName: string,
+  dest: string
+): Promise<void> {
+  const data = await readFile(join(__dirname, '../dev-server.go'), 'utf8');
+
+  // Populate the handler function name
+  const patched = data.replace('__HANDLER_FUNC_NAME', functionName);
+
+  await writeFile(join(dest, 'vercel-dev-server-main.go'), patched);
+}
+
+/**
+ * Writes a `_test.go` file next to the entrypoint that replays the requests
+ * recorded in the HAR file, unless one exists already so that edits to it
+ * are kept.
+ */
+async function writeReplayTest(
+  entrypoint: string,
+  packageName: string,
+  functionName: string,
+  harFile: string
+): Promise<void> {
+  const name = basename(entrypoint).replace(/\.go$/, '');
+  const dest = join(dirname(entrypoint), `${name}_replay_test.go`);
+  if (await pathExists(dest)) {
+    return;
+  }
+
+  const data = await readFile(join(__dirname, '../dev-replay-test.go'), 'utf8');
+  const harPath = relative(dirname(entrypoint), harFile)
+    .split(sep)
+    .join(posix.sep);
+  const patched = data
+    .replace('__VC_REPLAY_PACKAGE_NAME', packageName)
+    .replace(/__VC_REPLAY_HANDLER_FUNC_NAME/g, functionName)
+    .replace('__VC_REPLAY_HAR_FILE', harPath);
+
+  console.log(`Writing replay test "${dest}" for recorded requests`);
+  await writeFile(dest, patched);
+}
+
+/**
+ * For simple cases, a `go.work` file is not required. However when a Go
+ * program requires source files outside the work path, we need a `go.work` so
+ * Go can find the root of the project being built.
+ * @param destDir The destination directory to write the `go.work` file.
+ * @param workPath The path to the work directory.
+ * @param modulePath The path to the directory containing the `go.mod`.
+ */
+async function writeGoWork(
+  destDir: string,
+  workPath: string,
+  modulePath?: string
+) {
+  const workspaces = new Set(['.']);
+  const goWorkPath = await findGoWorkFile(modulePath || workPath, workPath);
+  let goVersion: string | undefined;
+
+  if (goWorkPath) {
+    const contents = await readFile(goWorkPath, 'utf-8');
+
+    // Extract go version if present
+    const goVersionMatch = contents.match(/^go\s+(\d+\.\d+(\.\d+)?)/m);
+    if (goVersionMatch) {
+      goVersion = goVersionMatch[1];
+    }
+
+    for (const path of parseGoWorkUse(contents)) {
+      if (path.startsWith('.')) {
+        workspaces.add(relative(destDir, join(workPath, path)));
+      } else {
+        workspaces.add(path);
+      }
+    }
+  } else if (modulePath) {
+    workspaces.add(relative(destDir, modulePath));
+
+    // If no existing go.work, try to get version from go.mod
+    const goModPath = join(modulePath, 'go.mod');
+    if (await pathExists(goModPath)) {
+      const goModContents = await readFile(goModPath, 'utf-8');
+      const goVersionMatch = goModContents.match(/^go\s+(\d+\.\d+(\.\d+)?)/m);
+      if (goVersionMatch) {
+        goVersion = goVersionMatch[1];
+      }
+    }
+  }
+
+  // Construct go.work contents with version directive if available
+  let contents = '';
+  if (goVersion) {
+    contents += `go ${goVersion}\n\n`;
+  }
+
+  contents += `use (\n${Array.from(workspaces)
+    .map(w => `  ${w}\n`)
+    .join('')})\n`;
+  // console.log(contents);
+  await writeFile(join(destDir, 'go.work'), contents, 'utf-8');
+}
+
+/**
+ * A running dev server process of an entrypoint.
+ */
+interface DevServer {
+  port: number;
+  pid: number;
+  /**
+   * Marks a request as in flight, thawing the process if it is frozen
+   */
+  acquire: () => void;
+  /**
+   * Marks the request as completed, freezing the process once it is idle
+   */
+  release: () => void;
+  isRunning: () => boolean;
+  shutdown: () => Promise<void>;
+  /**
+   * Asks the process to shut down, without waiting for it, when `vercel dev`
+   * exits
+   */
+  terminate: () => void;
+}
+
+interface DevServerPool {
+  fingerprint: string;
+  idle: DevServer[];
+}
+
+// In freeze mode, the dev servers of an entrypoint keep running across the
+// requests of `vercel dev` until its sources change, like warm instances in
+// production. Each of them handles a single request at a time, and another
+// one is started for concurrent requests.
+const pools = new Map<string, DevServerPool>();
+const running = new Set<DevServer>();
+let isExitHookRegistered = false;
+
+// The coverage data directories that were cleared during this run
+const coverDataDirs = new Set<string>();
+
+/**
+ * Returns the file that requests are recorded to, next to the original
+ * entrypoint unless configured otherwise.
+ */
+function getHarFile(
+  workPath: string,
+  entrypointWithExt: string,
+  { file }: { file?: string }
+): string {
+  if (file) {
+    return isAbsolute(file) ? file : join(workPath, file);
+  }
+  const name = basename(entrypointWithExt).replace(/\.go$/, '');
+  return join(workPath, dirname(entrypointWithExt), 'testdata', `${name}.har`);
+}
+
+// For some reason, if `entrypoint` is a path segment (filename contains `[]`
+// brackets) then the `.go` suffix on the entrypoint is missing. Fix that here…
+function getEntrypointWithExt(entrypoint: string): string {
+  return entrypoint.endsWith('.go') ? entrypoint : `${entrypoint}.go`;
+}
+
+/**
+ * Returns the state of the files that the dev server of an entrypoint may
+ * build or read, so that it is started again when any of them changes.
+ */
+async function getDevServerFingerprint({
+  entrypoint,
+  workPath,
+  config,
+  meta = {},
+}: StartDevServerOptions): Promise<string> {
+  const entrypointDir = join(workPath, dirname(entrypoint));
+  const { goModPath } = await findGoModPath(entrypointDir, workPath);
+  const modulePath = goModPath ? dirname(goModPath) : workPath;
+  const goWorkPath = await findGoWorkFile(modulePath, workPath);
+
+  // the files the dev servers write themselves, and tests
+  const devOptions = getDevOptions(cloneEnv(process.env, meta.env), config);
+  const outputs: string[] = [];
+  if (devOptions.record) {
+    const entrypointWithExt = getEntrypointWithExt(entrypoint);
+    outputs.push(getHarFile(workPath, entrypointWithExt, devOptions.record));
+  }
+  for (const dir of [devOptions.cassettes, devOptions.cover]) {
+    if (dir) {
+      outputs.push(isAbsolute(dir.dir) ? dir.dir : join(workPath, dir.dir));
+    }
+  }
+  const isOutput = (file: string) =>
+    file.endsWith('_test.go') ||
+    outputs.some(o => file === o || file.startsWith(`${o}${sep}`));
+
+  // the non-Go files of the module may be embedded, or read at runtime
+  const files = (await getGenerateInputs(modulePath, goWorkPath)).filter(
+    file => !isOutput(file)
+  );
+  if (goWorkPath) {
+    files.push(goWorkPath, `${goWorkPath}.sum`);
+  }
+  const includedFiles = await getIncludedFiles(config, entrypointDir);
+  for (const file of Object.values(includedFiles)) {
+    if (file.type === 'FileFsRef') {
+      files.push(file.fsPath);
+    }
+  }
+
+  const state = [JSON.stringify(config), JSON.stringify(meta.env)];
+  for (const file of files) {
+    const stat = await lstat(file).catch(() => undefined);
+    state.push(`${file}:${stat ? `${stat.size}:${stat.mtimeMs}` : ''}`);
+  }
+  return state.join('\n');
+}
+
+/**
+ * Returns an idle dev server of an entrypoint, or starts a new one if none is
+ * idle or its sources changed.
+ */
+async function getPooledDevServer(
+  name: string,
+  opts: StartDevServerOptions
+): Promise<{ pool: DevServerPool; server: DevServer }> {
+  const fingerprint = await getDevServerFingerprint(opts);
+  let pool = pools.get(name);
+  if (!pool || pool.fingerprint !== fingerprint) {
+    if (pool) {
+      debug(`Restarting the Go dev servers for "${opts.entrypoint}"`);
+      // the dev servers that are in flight shut down on release
+      const stopped = Promise.all(pool.idle.map(s => s.shutdown())).catch(
+        (err: Error) => {
+          console.error(`Could not shut down the Go dev server: ${err}`);
+        }
+      );
+      // Delve of the new dev server listens on the same address, which the
+      // attached debugger reconnects to
+      const env = cloneEnv(process.env, opts.meta && opts.meta.env);
+      if (getDevOptions(env, opts.config).debug) {
+        await stopped;
+      }
+    }
+    pool = { fingerprint, idle: [] };
+    pools.set(name, pool);
+  }
+
+  let server = pool.idle.pop();
+  while (server && !server.isRunning()) {
+    server = pool.idle.pop();
+  }
+  if (!server) {
+    server = await spawnDevServer(opts, true);
+  }
+  return { pool, server };
+}
+
+export async function startDevServer(
+  opts: StartDevServerOptions
+): Promise<StartDevServerResult> {
+  const { entrypoint, workPath, meta = {} } = opts;
+  const env = cloneEnv(process.env, meta.env);
+
+  // every request is handled by a new dev server, unless it is frozen between
+  // the requests of `vercel dev`
+  if (!meta.isDev || !getDevOptions(env, opts.config).freeze) {
+    const server = await spawnDevServer(opts, false);
+    const { port, pid, shutdown } = server;
+    return { port, pid, shutdown };
+  }
+
+  const name = join(workPath, getEntrypointWithExt(entrypoint));
+  const { pool, server } = await getPooledDevServer(name, opts);
+  server.acquire();
+  let isReleased = false;
+  const release = async () => {
+    if (isReleased) {
+      return;
+    }
+    isReleased = true;
+    if (pools.get(name) !== pool || !server.isRunning()) {
+      await server.shutdown();
+      return;
+    }
+    server.release();
+    pool.idle.push(server);
+  };
+  return { port: server.port, pid: server.pid, shutdown: release };
+}
+
+/**
+ * Stops the dev servers that are kept running across requests in freeze mode.
+ */
+export async function stopDevServers(): Promise<void> {
+  const servers = Array.from(running);
+  pools.clear();
+  await Promise.all(servers.map(s => s.shutdown().catch(() => {})));
+}
+
+interface CoverageProfile {
+  entrypoint: string;
+  workPath: string;
+  update: Promise<void>;
+  isQueued: boolean;
+}
+
+// The coverage profiles of this run, which are updated one after another as
+// dev servers exit, and printed once when `vercel dev` exits
+const coverageProfiles = new Map<string, CoverageProfile>();
+
+/**
+ * Merges the coverage data of the dev servers of an entrypoint into its
+ * profile. An update that is still queued covers the data of every dev
+ * server that exited in the meantime.
+ */
+function updateCoverageProfile(opts: CoverageProfileOptions): Promise<void> {
+  let profile = coverageProfiles.get(opts.profilePath);
+  if (!profile) {
+    profile = {
+      entrypoint: opts.entrypoint,
+      workPath: opts.workPath,
+      update: Promise.resolve(),
+      isQueued: false,
+    };
+    coverageProfiles.set(opts.profilePath, profile);
+  }
+  if (profile.isQueued) {
+    return profile.update;
+  }
+  const queued = profile;
+  queued.isQueued = true;
+  queued.update = queued.update
+    .then(() => {
+      queued.isQueued = false;
+      return writeCoverageProfile(opts);
+    })
+    .catch((err: Error) => {
+      console.error(
+        `Could not write coverage profile for "${opts.entrypoint}": ${err}`
+      );
+    });
+  return queued.update;
+}
+
+function registerExitHook() {
+  if (isExitHookRegistered) {
+    return;
+  }
+  isExitHookRegistered = true;
+  process.once('exit', () => {
+    for (const server of running) {
+      server.terminate();
+    }
+    for (const [profilePath, profile] of coverageProfiles) {
+      if (!pathExistsSync(profilePath)) {
+        continue;
+      }
+      try {
+        printCoverageReport(profile.entrypoint, profilePath, profile.workPath);
+      } catch (err: any) {
+        console.error(`Could not print coverage report: ${err}`);
+      }
+    }
+  });
+}
+
+async function spawnDevServer(
+  opts: StartDevServerOptions,
+  isPooled: boolean
+): Promise<DevServer> {
+  const { entrypoint, workPath, meta = {} } = opts;
+  const { devCacheDir = join(workPath, '.vercel', 'cache') } = meta;
+  const entrypointDir = dirname(entrypoint);
+  const entrypointWithExt = getEntrypointWithExt(entrypoint);
+
+  const tmp = join(devCacheDir, 'go', Math.random().toString(32).substring(2));
+  const tmpPackage = join(tmp, entrypointDir);
+  await mkdirp(tmpPackage);
+
+  const { goModPath } = await findGoModPath(
+    join(workPath, entrypointDir),
+    workPath
+  );
+  const modulePath = goModPath ? dirname(goModPath) : undefined;
+  const analyzed = await getAnalyzedEntrypoint({
+    entrypoint: entrypointWithExt,
+    modulePath,
+    workPath,
+  });
+
+  await Promise.all([
+    copyEntrypoint(join(workPath, entrypointWithExt), tmpPackage),
+    copyDevServer(analyzed.functionName, tmpPackage),
+    writeGoMod({
+      destDir: tmp,
+      goModPath,
+      packageName: analyzed.packageName,
+    }),
+    writeGoWork(tmp, workPath, modulePath),
+  ]);
+
+  const portFile = join(
+    TMP,
+    `vercel-dev-port-${Math.random().toString(32).substring(2)}`
+  );
+
+  const env = cloneEnv(process.env, meta.env, {
+    VERCEL_DEV_PORT_FILE: portFile,
+    VERCEL_DEV_GO_ENTRYPOINT: join(workPath, entrypointWithExt),
+  });
+
+  const devOptions = getDevOptions(env, opts.config);
+
+  // a file name for the per-entrypoint state shared by dev server processes
+  const entrypointName = entrypointWithExt
+    .replace(/\.go$/, '')
+    .replace(/[^\w.-]+/g, '_');
+
+  // the requests shown by the `/__vercel_go/requests` debug endpoint, logged
+  // by every dev server process of the entrypoint
+  env.VERCEL_DEV_GO_REQUEST_LOG = join(
+    devCacheDir,
+    'go',
+    `requests-${entrypointName}.log`
+  );
+
+  let raceLogPath: string | undefined;
+  if (devOptions.race) {
+    // the race detector requires cgo; reports are written to a log file that
+    // `dev-server.go` maps back to the source and prints, and they should not
+    // change the exit code of the dev server
+    raceLogPath = join(
+      TMP,
+      `vercel-dev-race-${Math.random().toString(32).substring(2)}`
+    );
+    env.CGO_ENABLED = '1';
+    env.GORACE = ['exitcode=0', env.GORACE, `log_path=${raceLogPath}`]
+      .filter(Boolean)
+      .join(' ');
+  }
+
+  // in strict mode, the working directory is read-only and the function may
+  // only write to its own tmp directory, like in production
+  if (devOptions.strict) {
+    const strictTmpDir = join(tmp, '.tmp');
+    await mkdirp(strictTmpDir);
+    env.TMPDIR = env.TMP = env.TEMP = strictTmpDir;
+    env.VERCEL_DEV_GO_MAX_DURATION = String(devOptions.strict.maxDuration);
+  }
+
+  if (devOptions.leaks) {
+    env.VERCEL_DEV_GO_LEAK_GRACE = String(devOptions.leaks.grace);
+  }
+
+  // the dev server runs in the task directory, so the fixture of the platform
+  // headers is resolved here
+  if (devOptions.platformHeaders) {
+    const { file } = devOptions.platformHeaders;
+    env.VERCEL_DEV_GO_PLATFORM_HEADERS = '1';
+    if (file) {
+      env.VERCEL_DEV_GO_PLATFORM_HEADERS_FILE = isAbsolute(file)
+        ? file
+        : join(workPath, file);
+    }
+  }
+
+  // requests are recorded next to the original entrypoint, where the replay
+  // test is generated
+  if (devOptions.record) {
+    const harFile = getHarFile(workPath, entrypointWithExt, devOptions.record);
+    env.VERCEL_DEV_GO_RECORD_FILE = harFile;
+    await writeReplayTest(
+      join(workPath, entrypointWithExt),
+      analyzed.packageName,
+      analyzed.functionName,
+      harFile
+    );
+  }
+
+  if (devOptions.cassettes) {
+    const { dir, mode } = devOptions.cassettes;
+    env.VERCEL_DEV_GO_CASSETTE_DIR = isAbsolute(dir)
+      ? dir
+      : join(workPath, dir);
+    env.VERCEL_DEV_GO_CASSETTE_MODE = mode;
+  }
+
+  // every dev server process writes its own CPU profile, which are merged
+  // into the `default.pgo` when the dev server exits
+  let pgoDir: string | undefined;
+  if (devOptions.pgo) {
+    pgoDir = join(tmp, '.pgo');
+    await mkdirp(pgoDir);
+    env.VERCEL_DEV_GO_PGO_DIR = pgoDir;
+  }
+
+  let coverDataDir: string | undefined;
+  let coverProfilePath: string | undefined;
+  if (devOptions.cover) {
+    // the coverage data of every dev server process of the entrypoint is
+    // collected in the same directory, and merged into a single profile
+    const coverDir = isAbsolute(devOptions.cover.dir)
+      ? devOptions.cover.dir
+      : join(workPath, devOptions.cover.dir);
+    coverDataDir = join(coverDir, 'data', entrypointName);
+    coverProfilePath = join(coverDir, `${entrypointName}.out`);
+    // the data of earlier runs of `vercel dev` is removed by its first dev
+    // server of the entrypoint
+    if (!coverDataDirs.has(coverDataDir)) {
+      coverDataDirs.add(coverDataDir);
+      await remove(coverDataDir);
+    }
+    await mkdirp(coverDataDir);
+    env.GOCOVERDIR = coverDataDir;
+  }
+
+  // Like the Lambda root in production, the working directory of the dev
+  // server contains the executable and the `includeFiles`. It is a dot
+  // directory so that `go build ./...` ignores any Go files it contains.
+  const taskDir = join(tmp, '.task');
+  await mkdirp(taskDir);
+  await download(
+    await getIncludedFiles(opts.config, join(workPath, entrypointDir)),
+    taskDir
+  );
+
+  const executable = join(
+    taskDir,
+    `vercel-dev-server-go${process.platform === 'win32' ? '.exe' : ''}`
+  );
+
+  // Note: We must run `go build`, then manually spawn the dev server instead
+  // of spawning `go run`. See https://github.com/vercel/vercel/pull/8718 for
+  // more info.
+
+  // build the dev server
+  const go = await createGo({
+    modulePath,
+    opts: {
+      cwd: tmp,
+      env,
+    },
+    workPath,
+  });
+  const buildFlags: string[] = [];
+  if (devOptions.debug) {
+    // disable optimizations and inlining so that breakpoints and variables
+    // map cleanly to the source
+    buildFlags.push('-gcflags=all=-N -l');
+  }
+  if (devOptions.race) {
+    buildFlags.push('-race');
+  }
+  if (devOptions.cover) {
+    buildFlags.push('-cover');
+  }
+  await go.build('./...', executable, {
+    flags: buildFlags,
+    strip: !devOptions.debug,
+  });
+
+  // most writes fail like in production, but not when the dev server runs as
+  // root, so `dev-server.go` reports the writes it finds after each request
+  if (devOptions.strict) {
+    await setReadOnly(taskDir, true);
+  }
+
+  let command = executable;
+  let args: string[] = [];
+  if (devOptions.debug) {
+    const { wait } = devOptions.debug;
+    const dlv = await lookPath('dlv', env.PATH);
+    if (dlv) {
+      const address = await getDebugAddress(devOptions.debug.address);
+      // Delve does not pass FD 3 through to the debuggee, so the dev server
+      // falls back to reporting its port via `VERCEL_DEV_PORT_FILE`
+      command = dlv;
+      args = [
+        'exec',
+        executable,
+        '--headless',
+        `--listen=${address}`,
+        '--api-version=2',
+        '--accept-multiclient',
+      ];
+      if (!wait) {
+        args.push('--continue');
+      }
+      console.log(
+        `Delve is listening on ${address} for "${entrypointWithExt}"${
+          wait ? ', waiting for a debugger to attach' : ''
+        }`
+      );
+      if (devOptions.coldStart) {
+        console.warn(
+          'Warning: in cold start mode, requests are handled by new processes that the debugger is not attached to'
+        );
+      }
+    } else {
+      console.warn(
+        'Warning: `dlv` was not found on PATH, starting the Go dev server without a debugger'
+      );
+    }
+  }
+
+  // run the dev server
+  debug(`SPAWNING ${command} ${args.join(' ')} CWD=${taskDir}`);
+  const child = spawn(command, args, {
+    cwd: taskDir,
+    env,
+    stdio: ['ignore', 'inherit', 'inherit', 'pipe'],
+  });
+  registerExitHook();
+
+  const onCleanup = new Promise<void>(resolve => {
+    child.on('close', async () => {
+      try {
+        if (devOptions.strict) {
+          await setReadOnly(taskDir, false);
+        }
+        if (raceLogPath) {
+          await remove(`${raceLogPath}.${child.pid}`);
+        }
+      } catch (err: any) {
+        console.error(`Could not clean up after the Go dev server: ${err}`);
+      }
+      // coverage data is only written when the dev server exits gracefully
+      if (coverDataDir && coverProfilePath) {
+        await updateCoverageProfile({
+          entrypoint: join(workPath, entrypointWithExt),
+          go,
+          dataDir: coverDataDir,
+          profilePath: coverProfilePath,
+          tmp,
+          tmpPackage,
+          workPath,
+        });
+      }
+      if (pgoDir) {
+        try {
+          await mergePgoProfiles(
+            go,
+            pgoDir,
+            join(workPath, entrypointDir, PGO_FILENAME)
+          );
+        } catch (err: any) {
+          console.error(
+            `Could not merge the CPU profile of "${entrypointWithExt}": ${err}`
+          );
+        }
+      }
+      try {
+        await retry(() => remove(tmp));
+      } catch (err: any) {
+        console.error(`Could not delete tmp directory: ${tmp}: ${err}`);
+      }
+      resolve();
+    });
+  });
+
+  const portPipe = child.stdio[3];
+  if (!isReadable(portPipe)) {
+    throw new Error('File descriptor 3 is not readable');
+  }
+
+  // `dev-server.go` writes the ephemeral port number to FD 3 to be consumed here
+  const onPort = new Promise<PortInfo>(resolve => {
+    portPipe.setEncoding('utf8');
+    portPipe.once('data', d => {
+      resolve({ port: Number(d) });
+    });
+  });
+  const onPortFile = waitForPortFile(portFile);
+  const onExit = once.spread<[number, string | null]>(child, 'exit');
+  const result = await Promise.race([onPort, onPortFile, onExit]);
+  onExit.cancel();
+  onPortFile.cancel();
+
+  if (isPortInfo(result)) {
+    const { port } = result;
+    const pid = child.pid as number;
+    let isShuttingDown = false;
+    let isFrozen = false;
+    let isIdle = false;
+    let isBusy = false;
+
+    // `dev-server.go` reports on FD 3 when no request is in flight anymore,
+    // which may be before or after `vercel dev` completes the response.
+    // Processes cannot be stopped on Windows, and stopping Delve would stop
+    // the debugger rather than the dev server.
+    const canFreeze =
+      isPooled &&
+      devOptions.freeze &&
+      process.platform !== 'win32' &&
+      !devOptions.debug;
+    const freezeWhenIdle = () => {
+      if (
+        canFreeze &&
+        isIdle &&
+        !isBusy &&
+        !isFrozen &&
+        !isShuttingDown &&
+        child.exitCode === null
+      ) {
+        debug(`Freezing Go dev server with PID ${pid}`);
+        child.kill('SIGSTOP');
+        isFrozen = true;
+      }
+    };
+    if (canFreeze) {
+      portPipe.on('data', (d: string) => {
+        if (d.includes('idle')) {
+          isIdle = true;
+          freezeWhenIdle();
+        }
+      });
+    }
+
+    child.on('exit', (exitCode, signal) => {
+      if (exitCode === 0) {
+        debug(`Go dev server for "${entrypointWithExt}" shut down gracefully`);
+      } else if (exitCode === DEV_SERVER_EXIT_DRAIN_TIMEOUT) {
+        console.warn(
+          `Go dev server for "${entrypointWithExt}" shut down before in-flight requests completed`
+        );
+      } else if (!isShuttingDown || exitCode !== null) {
+        const reason = signal ? `"${signal}" signal` : `exit code ${exitCode}`;
+        console.error(
+          `Go dev server for "${entrypointWithExt}" crashed with ${reason}`
+        );
+      }
+    });
+
+    // Ask the dev server to drain in-flight requests and run its shutdown
+    // hooks. On Windows, `SIGTERM` terminates the process immediately.
+    const terminate = () => {
+      isShuttingDown = true;
+      child.kill('SIGTERM');
+      if (isFrozen) {
+        // the dev server handles the pending signal once it is thawed
+        child.kill('SIGCONT');
+        isFrozen = false;
+      }
+    };
+
+    const shutdown = async () => {
+      if (child.exitCode !== null || child.signalCode !== null) {
+        return onCleanup;
+      }
+      // pending leak checks run before the dev server exits
+      const leakGrace = devOptions.leaks ? devOptions.leaks.grace : 0;
+      const killAfter =
+        getDrainTimeout(env) * 1000 + leakGrace + DEV_SERVER_SHUTDOWN_GRACE_MS;
+      const timeout = setTimeout(() => {
+        debug(`Go dev server did not exit in time, killing PID ${pid}`);
+        child.kill('SIGKILL');
+      }, killAfter);
+      terminate();
+      await onCleanup;
+      clearTimeout(timeout);
+    };
+
+    const server: DevServer = {
+      port,
+      pid,
+      acquire: () => {
+        isBusy = true;
+        isIdle = false;
+        if (isFrozen) {
+          debug(`Thawing Go dev server with PID ${pid}`);
+          child.kill('SIGCONT');
+          isFrozen = false;
+        }
+      },
+      release: () => {
+        isBusy = false;
+        freezeWhenIdle();
+      },
+      isRunning: () =>
+        !isShuttingDown && child.exitCode === null && child.signalCode === null,
+      shutdown,
+      terminate,
+    };
+    running.add(server);
+    child.once('exit', () => running.delete(server));
+    if (isPooled) {
+      // a dev server that is kept running does not keep `vercel dev` from
+      // exiting, which terminates it
+      child.unref();
+      (portPipe as Readable & { unref?: () => void }).unref?.();
+    }
+    return server;
+  } else if (Array.isArray(result)) {
+    // Got "exit" event from child process
+    const [exitCode, signal] = result;
+    const reason = signal ? `"${signal}" signal` : `exit code ${exitCode}`;
+    throw new Error(`\`go run ${entrypointWithExt}\` failed with ${reason}`);
+  } else {
+    throw new Error(`Unexpected result type: ${typeof result}`);
+  }
+}
+
+/**
+ * Returns the address for Delve to listen on, or a free port of the same host
+ * if it is in use, e.g. by the Delve of another entrypoint.
+ */
+async function getDebugAddress(address: string): Promise<string> {
+  const i = address.lastIndexOf(':');
+  const host = address.substring(0, i);
+  const listen = (port: number) =>
+    new Promise<number | undefined>(resolve => {
+      const server = createServer();
+      server.once('error', () => resolve(undefined));
+      server.listen(port, host, () => {
+        const info = server.address();
+        server.close(() => {
+          resolve(info && typeof info === 'object' ? info.port : undefined);
+        });
+      });
+    });
+
+  if (await listen(Number(address.substring(i + 1)))) {
+    return address;
+  }
+  const port = await listen(0);
+  if (!port) {
+    return address;
+  }
+  console.warn(
+    `Warning: ${address} is in use, starting Delve on port ${port} instead`
+  );
+  return `${host}:${port}`;
+}
+
+// The dev servers of an entrypoint may exit at the same time, so their
+// profiles are merged into the `default.pgo` one after another
+let pgoMerge: Promise<void> = Promise.resolve();
+
+/**
+ * Merges the CPU profiles in `dir` into the `dest` profile.
+ */
+async function mergePgoProfiles(
+  go: GoWrapper,
+  dir: string,
+  dest: string
+): Promise<void> {
+  const profiles: string[] = [];
+  for (const name of await readdir(dir)) {
+    const fsPath = join(dir, name);
+    // the profile of a dev server that was killed is empty
+    if (name.endsWith('.pprof') && (await lstat(fsPath)).size > 0) {
+      profiles.push(fsPath);
+    }
+  }
+  if (profiles.length === 0) {
+    return;
+  }
+
+  const merge = pgoMerge.then(async () => {
+    const exists = await pathExists(dest);
+    const merged = join(dir, PGO_FILENAME);
+    await go.mergeProfiles(exists ? [dest, ...profiles] : profiles, merged);
+    await move(merged, dest, { overwrite: true });
+    if (!exists) {
+      console.log(`Created "${dest}" from the CPU profile of the dev server`);
+    }
+  });
+  pgoMerge = merge.catch(() => undefined);
+  await merge;
+}
+
+/**
+ * Recursively removes (or restores) the write permissions of the files and
+ * directories in `dir`.
+ * @param dir The directory to update
+ * @param readOnly Whether to make the files read-only or writeable
+ */
+async function setReadOnly(dir: string, readOnly: boolean): Promise<void> {
+  for (const name of await readdir(dir)) {
+    const fsPath = join(dir, name);
+    const stat = await lstat(fsPath);
+    if (stat.isSymbolicLink()) {
+      continue;
+    }
+    if (stat.isDirectory()) {
+      await setReadOnly(fsPath, readOnly);
+    } else {
+      await chmod(fsPath, readOnly ? stat.mode & ~0o222 : stat.mode | 0o200);
+    }
+  }
+  const stat = await lstat(dir);
+  await chmod(dir, readOnly ? stat.mode & ~0o222 : stat.mode | 0o200);
+}
+
+/**
+ * Returns the number of seconds the dev server waits for in-flight requests
+ * when shutting down, as configured by `VERCEL_DEV_GO_DRAIN_TIMEOUT`.
+ */
+function getDrainTimeout(env: Env): number {
+  const timeout = Number(env.VERCEL_DEV_GO_DRAIN_TIMEOUT);
+  return Number.isInteger(timeout) && timeout >= 0
+    ? timeout
+    : DEV_SERVER_DRAIN_TIMEOUT;
+}
+
+export interface CancelablePromise<T> extends Promise<T> {
+  cancel: () => void;
+}
+
+function waitForPortFile(portFile: string) {
+  const opts = { portFile, canceled: false };
+  const promise = waitForPortFile_(opts) as CancelablePromise<PortInfo | void>;
+  promise.cancel = () => {
+    opts.canceled = true;
+  };
+  return promise;
+}
+
+async function waitForPortFile_(opts: {
+  portFile: string;
+  canceled: boolean;
+}): Promise<PortInfo | void> {
+  while (!opts.canceled) {
+    await new Promise(resolve => setTimeout(resolve, 100));
+    try {
+      const port = Number(await readFile(opts.portFile, 'ascii'));
+      retry(() => remove(opts.portFile)).catch((err: Error) => {
+        console.error(`Could not delete port file: ${opts.portFile}: ${err}`);
+      });
+      return { port };
+    } catch (err: any) {
+      if (err.code !== 'ENOENT') {
+        throw err;
+      }
+    }
+  }
+}
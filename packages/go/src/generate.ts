@@ -0,0 +1,229 @@
+import { dirname, join, relative } from 'path';
+import { lstat, readdir, readFile } from 'fs-extra';
+import { debug, glob } from '@vercel/build-utils';
+import type { Config, Env } from '@vercel/build-utils';
+import { getBooleanConfig } from './checks';
+import { parseGoWorkUse } from './go-helpers';
+import type { GoWrapper } from './go-helpers';
+
+// Directories that are neither generated into nor read by `go generate`
+const ignoredDirs = new Set(['.git', '.vercel', 'node_modules']);
+
+/**
+ * Whether `go generate` runs before the build, enabled with the `goGenerate`
+ * config.
+ */
+export function isGenerateEnabled(config: Config = {}): boolean {
+  return getBooleanConfig(config, 'goGenerate');
+}
+
+/**
+ * Whether a `go.mod` declares tools with `tool` directives (go 1.24).
+ */
+export function hasToolDirectives(goMod: string): boolean {
+  return /^\s*tool(\s*\(|\s+\S)/m.test(goMod);
+}
+
+/**
+ * Parses the blank imports of a `tools.go` file, which pins the versions of
+ * the tools of a module behind a `tools` build constraint.
+ *
+ * @returns The packages of the tools, or none if the file is not constrained
+ * to the `tools` build tag
+ */
+export function parseToolsGoImports(contents: string): string[] {
+  if (!/^\/\/(go:build|\s*\+build)\s.*\btools\b/m.test(contents)) {
+    return [];
+  }
+  const imports: string[] = [];
+  const importRE = /(?:^|\s)_\s+"([^"]+)"/gm;
+  let match = importRE.exec(contents);
+  while (match) {
+    imports.push(match[1]);
+    match = importRE.exec(contents);
+  }
+  return imports;
+}
+
+/**
+ * Returns the directories of the system commands, e.g. `sh` or `protoc`,
+ * that `//go:generate` directives may run besides the tools of the module.
+ */
+function getSystemPath(env: Env): string[] {
+  if (process.platform === 'win32') {
+    return [join(env.SystemRoot || 'C:\\Windows', 'System32')];
+  }
+  return ['/usr/local/bin', '/usr/bin', '/bin'];
+}
+
+async function walk(dir: string, files: string[] = []): Promise<string[]> {
+  for (const name of await readdir(dir)) {
+    const path = join(dir, name);
+    const stat = await lstat(path);
+    if (stat.isDirectory()) {
+      if (!ignoredDirs.has(name)) {
+        await walk(path, files);
+      }
+    } else {
+      files.push(path);
+    }
+  }
+  return files;
+}
+
+/**
+ * Records the size and modification time of the files under `dir`, to tell
+ * which files `go generate` writes or deletes.
+ */
+async function snapshot(dir: string): Promise<Map<string, string>> {
+  const files = new Map<string, string>();
+  for (const path of await walk(dir)) {
+    const stat = await lstat(path);
+    files.set(relative(dir, path), `${stat.size}:${stat.mtimeMs}`);
+  }
+  return files;
+}
+
+/**
+ * Returns the files that `go generate` may read: any file of the module or of
+ * the modules of its workspace, e.g. a SQL schema or a `.proto` file, besides
+ * the Go files of the import graph.
+ *
+ * @param modulePath The directory of the `go.mod`
+ * @param goWorkPath The `go.work` of the module, if any
+ */
+export async function getGenerateInputs(
+  modulePath: string,
+  goWorkPath?: string
+): Promise<string[]> {
+  const dirs = [modulePath];
+  if (goWorkPath) {
+    const contents = await readFile(goWorkPath, 'utf8');
+    for (const use of parseGoWorkUse(contents)) {
+      dirs.push(join(dirname(goWorkPath), use));
+    }
+  }
+  const files = new Set<string>();
+  for (const dir of dirs) {
+    for (const file of await walk(dir)) {
+      files.add(file);
+    }
+  }
+  return Array.from(files).sort();
+}
+
+/**
+ * Builds the tools of a module to `dest`: those of the `tool` directives of
+ * its `go.mod`, and those imported by its `tools.go` files.
+ *
+ * @returns Whether the module has any tools
+ */
+async function buildTools(
+  go: GoWrapper,
+  modulePath: string,
+  dest: string
+): Promise<boolean> {
+  const packages = new Set<string>();
+  const goMod = await readFile(join(modulePath, 'go.mod'), 'utf8');
+  if (hasToolDirectives(goMod)) {
+    packages.add('tool');
+  }
+  const toolsGoFiles = await glob('**/tools.go', {
+    cwd: modulePath,
+    ignore: ['**/node_modules/**', '**/testdata/**', 'vendor/**'],
+  });
+  for (const file of Object.values(toolsGoFiles)) {
+    const contents = await readFile(file.fsPath, 'utf8');
+    for (const pkg of parseToolsGoImports(contents)) {
+      packages.add(pkg);
+    }
+  }
+  if (packages.size === 0) {
+    return false;
+  }
+
+  debug(`Building the tools of ${modulePath} to ${dest}`);
+  try {
+    await go.buildTools(Array.from(packages), dest);
+  } catch (err) {
+    console.error('Failed to build the tools for `go generate`');
+    throw err;
+  }
+  return true;
+}
+
+export interface GeneratedFiles {
+  /**
+   * The files that were created or modified, relative to the module
+   */
+  written: string[];
+  /**
+   * The files that were deleted, relative to the module
+   */
+  deleted: string[];
+}
+
+/**
+ * Runs `go generate ./...` in a module. The `PATH` of the generators has the
+ * `go` command, the tools of the module (see `buildTools()`) and the system
+ * directories only, so that the generated files do not depend on the tools
+ * installed on the build machine.
+ *
+ * @param go A `GoWrapper` of the module
+ * @param modulePath The directory of the `go.mod`
+ * @param toolsPath A directory to build the tools of the module to
+ * @param env The build environment
+ * @returns The files that were written and deleted
+ */
+export async function runGoGenerate({
+  go,
+  modulePath,
+  toolsPath,
+  env,
+}: {
+  go: GoWrapper;
+  modulePath: string;
+  toolsPath: string;
+  env: Env;
+}): Promise<GeneratedFiles> {
+  const path = getSystemPath(env);
+  if (await buildTools(go, modulePath, toolsPath)) {
+    path.unshift(toolsPath);
+  }
+
+  const before = await snapshot(modulePath);
+  console.log('Running `go generate ./...`');
+  try {
+    await go.generate([join(modulePath, '...')], path);
+  } catch (err) {
+    console.error('Failed to `go generate`');
+    throw err;
+  }
+  const after = await snapshot(modulePath);
+
+  const written: string[] = [];
+  for (const [file, stat] of after) {
+    if (before.get(file) !== stat) {
+      written.push(file);
+    }
+  }
+  const deleted = Array.from(before.keys()).filter(file => !after.has(file));
+  written.sort();
+  deleted.sort();
+
+  if (written.length === 0 && deleted.length === 0) {
+    console.log('`go generate` did not write any files');
+  }
+  const report = (verb: string, files: string[]) => {
+    if (files.length > 0) {
+      const n = files.length;
+      console.log(`\`go generate\` ${verb} ${n} file${n === 1 ? '' : 's'}:`);
+      for (const file of files) {
+        console.log(`  ${file}`);
+      }
+    }
+  };
+  report('wrote', written);
+  report('deleted', deleted);
+  return { written, deleted };
+}
@@ -1,23 +1,33 @@
 import tar from 'tar';
 import execa from 'execa';
+import { createHash } from 'crypto';
 import fetch from 'node-fetch';
 import {
+  createReadStream,
   createWriteStream,
   mkdirp,
+  move,
   pathExists,
   readFile,
+  readlink,
   remove,
   symlink,
+  writeFile,
 } from 'fs-extra';
-import { delimiter, dirname, join } from 'path';
+import { delimiter, dirname, join, normalize, relative, sep } from 'path';
 import stringArgv from 'string-argv';
-import { cloneEnv, debug } from '@vercel/build-utils';
-import { pipeline } from 'stream';
+import { cloneEnv, debug, glob, streamToBuffer } from '@vercel/build-utils';
+import { pipeline, Transform } from 'stream';
 import { promisify } from 'util';
-import { tmpdir } from 'os';
 import yauzl from 'yauzl-promise';
 import XDGAppPaths from 'xdg-app-paths';
-import type { Env } from '@vercel/build-utils';
+import type { Config, Env, Files } from '@vercel/build-utils';
+import {
+  compareGoVersions,
+  maxGoVersion,
+  parseGoToolchainEnv,
+  selectGoToolchain,
+} from './toolchain';
 
 const streamPipeline = promisify(pipeline);
 
@@ -40,17 +50,32 @@ const archMap = new Map([
 ]);
 const platformMap = new Map([['win32', 'windows']]);
 export const localCacheDir = join('.vercel', 'cache', 'golang');
+export const localBuildCacheDir = join('.vercel', 'cache', 'go-build');
+export const localModCacheDir = join('.vercel', 'cache', 'go-mod');
 
 const GO_FLAGS = process.platform === 'win32' ? [] : ['-ldflags', '-s -w'];
 const GO_MIN_MAJOR_VERSION = 1;
 const GO_MIN_MINOR_VERSION = 13;
 
+// `-pgo` is supported since go 1.21
+const PGO_MIN_MINOR_VERSION = 21;
+
+// The CPU profile used for profile-guided optimization, in the directory of
+// the entrypoint like `go build -pgo=auto` expects it in the main package.
+// Profiles recorded by the dev server name the functions of the entrypoint
+// file after `package main`, so only those of other packages are optimized.
+export const PGO_FILENAME = 'default.pgo';
+
+// The default location of the Go SDK archives, see `GO_SDK_MIRROR`
+const GO_SDK_URL = 'https://dl.google.com/go';
+
 /**
  * Determines the URL to download the Golang SDK.
  * @param version The desireed Go version
+ * @param mirror The base URL or local directory of the SDK archives
  * @returns The Go download URL
  */
-function getGoUrl(version: string) {
+function getGoUrl(version: string, mirror = GO_SDK_URL) {
   const { arch, platform } = process;
   const ext = platform === 'win32' ? 'zip' : 'tar.gz';
   const goPlatform = platformMap.get(platform) || platform;
@@ -69,7 +94,9 @@ function getGoUrl(version: string) {
   const filename = `go${version}.${goPlatform}-${goArch}.${ext}`;
   return {
     filename,
-    url: `https://dl.google.com/go/${filename}`,
+    url: /^https?:\/\//.test(mirror)
+      ? `${mirror.replace(/\/+$/, '')}/${filename}`
+      : join(mirror.replace(/^file:\/\//, ''), filename),
   };
 }
 
@@ -83,7 +110,7 @@ export const OUT_EXTENSION = process.platform === 'win32' ? '.exe' : '';
 interface Analyzed {
   functionName: string;
   packageName: string;
-  watch?: boolean;
+  watch?: string[];
 }
 
 /**
@@ -91,16 +118,20 @@ interface Analyzed {
  * @param workPath The work path (e.g. `/path/to/project`)
  * @param entrypoint The path to the entrypoint file (e.g.
  * `/path/to/project/api/index.go`)
+ * @param goWorkPath The path to the `go.work` of the module, if any, whose
+ * modules are part of the import graph of the entrypoint
  * @param modulePath The path to the directory containing the `go.mod` (e.g.
  * `/path/to/project/api`)
  * @returns The results from the AST parsing
  */
 export async function getAnalyzedEntrypoint({
   entrypoint,
+  goWorkPath,
   modulePath,
   workPath,
 }: {
   entrypoint: string;
+  goWorkPath?: string;
   modulePath?: string;
   workPath: string;
 }): Promise<Analyzed> {
@@ -142,7 +173,11 @@ export async function getAnalyzedEntrypoint({
 
   try {
     debug(`Analyzing entrypoint ${entrypoint} with modulePath ${modulePath}`);
-    const args = [`-modpath=${modulePath}`, join(workPath, entrypoint)];
+    const args = [`-modpath=${modulePath}`];
+    if (goWorkPath) {
+      args.push(`-gowork=${goWorkPath}`);
+    }
+    args.push(join(workPath, entrypoint));
     analyzed = await execa.stdout(bin, args);
   } catch (err) {
     console.error(`Failed to parse AST for "${entrypoint}"`);
@@ -187,10 +222,166 @@ export class GoWrapper {
     return execa('go', args, { stdio: 'inherit', ...opts, env });
   }
 
+  private async output(...args: string[]) {
+    const { opts, env } = this;
+    debug(`Exec: go ${args.join(' ')}`);
+    const { stdout } = await execa('go', args, {
+      ...opts,
+      env,
+      stdio: 'pipe',
+    });
+    return stdout;
+  }
+
   mod() {
     return this.execute('mod', 'tidy');
   }
 
+  /**
+   * Lists the packages in the dependency graph of `src` that cannot be
+   * loaded, e.g. because their module is neither vendored nor in the module
+   * cache when building offline.
+   *
+   * @returns The import path and error of each package
+   */
+  async listPackageErrors(src: string[]): Promise<string[]> {
+    const stdout = await this.output(
+      'list',
+      '-e',
+      '-deps',
+      '-f',
+      '{{if .Error}}{{.ImportPath}}: {{.Error.Err}}{{end}}',
+      ...src
+    );
+
+    // an error may continue on indented lines
+    const errors: string[] = [];
+    for (const line of stdout.split(/\r?\n/)) {
+      if (/^\s/.test(line) && errors.length > 0) {
+        errors[errors.length - 1] += `\n${line}`;
+      } else if (line) {
+        errors.push(line);
+      }
+    }
+    return errors;
+  }
+
+  /**
+   * Lists the main modules, which are all modules of the workspace when a
+   * `go.work` is in use.
+   */
+  async listModules(): Promise<GoModule[]> {
+    const stdout = await this.output(
+      'list',
+      '-m',
+      '-f',
+      '{{.Path}}\t{{.Dir}}'
+    );
+    return stdout
+      .split(/\r?\n/)
+      .filter(Boolean)
+      .map(line => {
+        const [path, dir] = line.split('\t');
+        return { path, dir };
+      });
+  }
+
+  /**
+   * Lists the packages of the main modules, and of the modules replaced by a
+   * local directory, in the dependency graph of `src`.
+   *
+   * @param flags Build flags, e.g. `-tags`
+   */
+  async listLocalPackages(src: string[], flags: string[] = []) {
+    const stdout = await this.output(
+      'list',
+      '-deps',
+      ...flags,
+      '-f',
+      '{{with .Module}}{{if or .Main (and .Replace (not .Replace.Version))}}{{$.ImportPath}}{{end}}{{end}}',
+      ...src
+    );
+    return stdout.split(/\r?\n/).filter(Boolean);
+  }
+
+  /**
+   * Runs `go vet` on the packages. It does not throw if `go vet` reports
+   * issues, which are written to `stderr`.
+   */
+  vet(packages: string[], flags: string[] = []) {
+    const { opts, env } = this;
+    debug(`Exec: go vet ${packages.join(' ')}`);
+    return execa('go', ['vet', ...flags, ...packages], {
+      ...opts,
+      env,
+      reject: false,
+      stdio: 'pipe',
+    });
+  }
+
+  /**
+   * Runs the tests of the packages with `go test -json`. It does not throw if
+   * tests fail. The tests are built for the platform of the build machine
+   * rather than `GOOS` and `GOARCH`, since their binaries are executed.
+   */
+  test(packages: string[], flags: string[] = []) {
+    const { opts, env } = this;
+    debug(`Exec: go test -json ${packages.join(' ')}`);
+    return execa('go', ['test', '-json', ...flags, ...packages], {
+      ...opts,
+      env: { ...env, GOOS: process.env.GOOS, GOARCH: process.env.GOARCH },
+      reject: false,
+      stdio: 'pipe',
+    });
+  }
+
+  /**
+   * Builds the commands of the packages to `dest`, e.g. the tools that
+   * `go generate` runs. They are built for the platform of the build machine
+   * rather than `GOOS` and `GOARCH`, since they are executed.
+   */
+  buildTools(packages: string[], dest: string) {
+    const { opts, env } = this;
+    debug(`Exec: go build -o ${dest}${sep} ${packages.join(' ')}`);
+    return execa('go', ['build', '-o', `${dest}${sep}`, ...packages], {
+      stdio: 'inherit',
+      ...opts,
+      env: { ...env, GOOS: process.env.GOOS, GOARCH: process.env.GOARCH },
+    });
+  }
+
+  /**
+   * Runs `go generate` on the packages with a `PATH` of the `go` command and
+   * the given directories only, so that the generators are the tools of the
+   * module rather than whichever are installed.
+   */
+  async generate(packages: string[], path: string[]) {
+    const { opts, env } = this;
+    const go = await lookPath('go', env.PATH);
+    const dirs = go ? [dirname(go), ...path] : path;
+    debug(`Exec: go generate ${packages.join(' ')}`);
+    debug(`  PATH=${dirs.join(delimiter)}`);
+    return execa('go', ['generate', ...packages], {
+      stdio: 'inherit',
+      ...opts,
+      env: { ...env, PATH: dirs.join(delimiter) },
+    });
+  }
+
+  /**
+   * Converts the binary coverage data written to a `GOCOVERDIR` to a text
+   * coverage profile.
+   */
+  coverageProfile(dataDir: string, dest: string) {
+    return this.execute(
+      'tool',
+      'covdata',
+      'textfmt',
+      `-i=${dataDir}`,
+      `-o=${dest}`
+    );
+  }
+
   get(src?: string) {
     const args = ['get'];
     if (src) {
@@ -202,29 +393,157 @@ export class GoWrapper {
     return this.execute(...args);
   }
 
-  build(src: string | string[], dest: string) {
-    debug(`Building optimized 'go' binary ${src} -> ${dest}`);
+  /**
+   * Returns the version of the `go` toolchain.
+   */
+  async version() {
+    return parseGoVersionString(await this.output('version'));
+  }
+
+  /**
+   * Merges CPU profiles into a single profile, e.g. a `default.pgo`.
+   */
+  mergeProfiles(sources: string[], dest: string) {
+    return this.output(
+      'tool',
+      'pprof',
+      '-proto',
+      `-output=${dest}`,
+      ...sources
+    );
+  }
+
+  async build(
+    src: string | string[],
+    dest: string,
+    { flags = [], strip = true, pgo }: GoBuildOptions = {}
+  ) {
+    debug(
+      `Building ${strip ? 'optimized' : 'debuggable'} 'go' binary ${src} -> ${dest}`
+    );
     const sources = Array.isArray(src) ? src : [src];
 
     const envGoBuildFlags = (this.env || this.opts.env).GO_BUILD_FLAGS;
-    const flags = envGoBuildFlags ? stringArgv(envGoBuildFlags) : GO_FLAGS;
+    const defaultFlags = strip ? GO_FLAGS : [];
+    const baseFlags = envGoBuildFlags
+      ? stringArgv(envGoBuildFlags)
+      : defaultFlags;
+
+    const pgoFlags: string[] = [];
+    if (pgo) {
+      const { major, minor, short } = await this.version();
+      if (major > 1 || (major === 1 && minor >= PGO_MIN_MINOR_VERSION)) {
+        debug(`Using profile-guided optimization with ${pgo}`);
+        pgoFlags.push(`-pgo=${pgo}`);
+      } else {
+        console.warn(
+          `Warning: ignoring ${pgo}, profile-guided optimization requires go 1.${PGO_MIN_MINOR_VERSION} or newer (using go ${short})`
+        );
+      }
+    }
 
-    return this.execute('build', ...flags, '-o', dest, ...sources);
+    return this.execute(
+      'build',
+      ...baseFlags,
+      ...pgoFlags,
+      ...flags,
+      '-o',
+      dest,
+      ...sources
+    );
   }
 }
 
+export interface GoModule {
+  path: string;
+  dir: string;
+}
+
+export interface GoBuildOptions {
+  /**
+   * Additional flags passed to `go build`, after `GO_BUILD_FLAGS`
+   */
+  flags?: string[];
+  /**
+   * When `false`, the default `-ldflags "-s -w"` are omitted so that the
+   * binary keeps its symbol table and DWARF debug information
+   */
+  strip?: boolean;
+  /**
+   * A CPU profile to build with profile-guided optimization, e.g. a
+   * `default.pgo`
+   */
+  pgo?: string;
+}
+
 type CreateGoOptions = {
   modulePath?: string;
   opts?: execa.Options;
+  /**
+   * The directory to stop looking for a `go.work` file, defaults to
+   * `workPath`
+   */
+  rootPath?: string;
   workPath: string;
 };
 
+/**
+ * Whether to build without network access (`GO_BUILD_OFFLINE`), using only
+ * the `vendor` directory or the module cache.
+ */
+export function isOfflineBuild(env: Env): boolean {
+  return env.GO_BUILD_OFFLINE === '1' || env.GO_BUILD_OFFLINE === 'true';
+}
+
+/**
+ * Returns the version of the Go on the `PATH`, if any.
+ */
+async function getLocalGoVersion(env: Env): Promise<string | undefined> {
+  try {
+    const { stdout } = await execa('go', ['version'], {
+      env: { ...env, GOROOT: undefined, GOTOOLCHAIN: 'local' },
+    });
+    return parseGoVersionString(stdout).version;
+  } catch {
+    return undefined;
+  }
+}
+
+/**
+ * Symlinks the global Go cache directory to the local cache directory. Builds
+ * of several entrypoints may do so in parallel, so an existing link to the
+ * same directory is kept.
+ */
+async function linkGoCacheDir(target: string, path: string): Promise<void> {
+  const isLinked = async () => {
+    try {
+      return (await readlink(path)) === target;
+    } catch {
+      return false;
+    }
+  };
+
+  if (await isLinked()) {
+    return;
+  }
+  await remove(path);
+  await mkdirp(dirname(path));
+  try {
+    await symlink(target, path);
+  } catch (err: any) {
+    if (err.code !== 'EEXIST' || !(await isLinked())) {
+      throw err;
+    }
+  }
+}
+
 /**
  * Initializes a `GoWrapper` instance.
  *
- * This function determines the Go version to use by first looking in the
- * `go.mod`, if exists, otherwise uses the latest version from the version
- * map.
+ * This function determines the Go version to use like the `go` command: the
+ * newest version required by the `go.work` and the modules it uses, or else
+ * by the `go.mod`, if exists, otherwise the latest version from the version
+ * map. `GOTOOLCHAIN` may pin a version or the Go on the `PATH` instead.
  *
  * Next it will attempt to find the desired Go version by checking the
  * following locations:
@@ -238,6 +557,7 @@ type CreateGoOptions = {
  * cache directory so that `prepareCache` will persist it.
  *
  * @param modulePath The path possibly containing a `go.mod` file
+ * @param rootPath The directory to stop looking for a `go.work` file
  * @param opts `execa` options (`cwd`, `env`, `stdio`, etc)
  * @param workPath The path to the project to be built
  * @returns An initialized `GoWrapper` instance
@@ -246,42 +566,52 @@ export async function createGo({
   modulePath,
   opts = {},
   workPath,
+  rootPath = workPath,
 }: CreateGoOptions): Promise<GoWrapper> {
-  // parse the `go.mod`, if exists
-  let goPreferredVersion: GoVersions | undefined;
-  if (modulePath) {
-    goPreferredVersion = await parseGoModVersionFromModule(modulePath);
+  const env = cloneEnv(process.env, opts.env);
+  const { GOTOOLCHAIN, PATH } = env;
+  const { platform } = process;
+
+  // the version required by the `go.work` or `go.mod`, if exists
+  const requirement = await getGoRequirement(modulePath, rootPath, env);
+  const gotoolchain = parseGoToolchainEnv(GOTOOLCHAIN);
+  const selection = selectGoToolchain({
+    required: requirement,
+    source: requirement && relative(rootPath, requirement.source),
+    gotoolchain,
+    // default to newest (first) supported go version
+    latest: Array.from(versionMap.values())[0],
+    local:
+      gotoolchain && gotoolchain.version === 'local'
+        ? await getLocalGoVersion(env)
+        : undefined,
+  });
+  const goSelectedVersion = selection.version;
+  debug(`Preferred go version ${goSelectedVersion} (${selection.reason})`);
+
+  if (requirement) {
+    env.GO111MODULE = 'on';
   }
 
-  // default to newest (first) supported go version
-  const goSelectedVersion = goPreferredVersion
-    ? goPreferredVersion.toolchain || goPreferredVersion.go
-    : Array.from(versionMap.values())[0];
+  // the toolchain is selected here, so `go` must not switch to another one
+  env.GOTOOLCHAIN = 'local';
+
+  if (goSelectedVersion === 'local') {
+    debug(`Selected go from the system PATH (${selection.reason})`);
+    env.GOROOT = undefined;
+    return new GoWrapper(env, opts);
+  }
 
-  const env = cloneEnv(process.env, opts.env);
-  const { PATH } = env;
-  const { platform } = process;
   const goGlobalCacheDir = join(
     goGlobalCachePath,
     `${goSelectedVersion}_${platform}_${process.arch}`
   );
   const goCacheDir = join(workPath, localCacheDir);
 
-  if (goPreferredVersion) {
-    debug(`Preferred go version ${goSelectedVersion} (from go.mod)`);
-    env.GO111MODULE = 'on';
-  } else {
-    debug(
-      `Preferred go version ${goSelectedVersion} (latest from version map)`
-    );
-  }
-
   const setGoEnv = async (goDir: string | null) => {
     if (platform !== 'win32' && goDir === goGlobalCacheDir) {
       debug(`Symlinking ${goDir} -> ${goCacheDir}`);
-      await remove(goCacheDir);
-      await mkdirp(dirname(goCacheDir));
-      await symlink(goDir, goCacheDir);
+      await linkGoCacheDir(goDir, goCacheDir);
       goDir = goCacheDir;
     }
     env.GOROOT = goDir || undefined;
@@ -328,10 +658,25 @@ export async function createGo({
     }
   }
 
+  if (isOfflineBuild(env)) {
+    throw new Error(
+      `Go ${goSelectedVersion} (${selection.reason}) is not installed and cannot be downloaded because \`GO_BUILD_OFFLINE\` is set. Install it, or restore the build cache (${localCacheDir}).`
+    );
+  }
+  if (!selection.download) {
+    throw new Error(
+      `Go ${goSelectedVersion} (${selection.reason}) is not installed and cannot be downloaded because of GOTOOLCHAIN=${GOTOOLCHAIN}. Add it to the PATH.`
+    );
+  }
+
   // we need to download and cache the desired `go` version
   await download({
     dest: goGlobalCacheDir,
     version: goSelectedVersion,
+    mirror: env.GO_SDK_MIRROR,
+    fetchChecksum:
+      env.GO_SDK_FETCH_CHECKSUM === '1' ||
+      env.GO_SDK_FETCH_CHECKSUM === 'true',
   });
 
   await setGoEnv(goGlobalCacheDir);
@@ -339,65 +684,211 @@ export async function createGo({
 }
 
 /**
- * Download and installs the Go distribution.
- *
- * @param dest The directory to install Go into. If directory exists, it is
- * first deleted before installing.
- * @param version The Go version to download
+ * Loads the SHA-256 checksums of the Go SDK archives shipped with the runtime
+ * (see `scripts/update-go-sdk-checksums.mjs`).
  */
-async function download({ dest, version }: { dest: string; version: string }) {
-  const { filename, url } = getGoUrl(version);
-  console.log(`Downloading go: ${url}`);
-  const res = await fetch(url);
+async function loadGoChecksums(): Promise<Record<string, string>> {
+  const file = join(__dirname, '../go-sdk-checksums.json');
+  return JSON.parse(await readFile(file, 'utf8'));
+}
 
+/**
+ * Reads an SDK archive, or its `.sha256` checksum file, from a URL or a local
+ * path.
+ */
+async function fetchSdkFile(url: string): Promise<NodeJS.ReadableStream> {
+  if (!/^https?:\/\//.test(url)) {
+    if (!(await pathExists(url))) {
+      throw new Error(`Failed to download: ${url} (not found)`);
+    }
+    return createReadStream(url);
+  }
+  const res = await fetch(url);
   if (!res.ok) {
     throw new Error(`Failed to download: ${url} (${res.status})`);
   }
+  return res.body;
+}
+
+/**
+ * Looks up the expected checksum of an SDK archive in the manifest. Versions
+ * that are not in the manifest are only verified against the `.sha256` file
+ * published with the archive at `checksumOrigin` with `fetchChecksum`, as set
+ * by `GO_SDK_FETCH_CHECKSUM`. The checksum is never read from the mirror,
+ * which could serve one matching a tampered archive.
+ */
+async function getGoChecksum(
+  filename: string,
+  checksums: Record<string, string>,
+  checksumOrigin: string,
+  fetchChecksum: boolean
+): Promise<string> {
+  if (checksums[filename]) {
+    return checksums[filename];
+  }
+  if (!fetchChecksum) {
+    throw new Error(
+      `Cannot verify ${filename}: it is not in the checksum manifest of @vercel/go. Set \`GO_SDK_FETCH_CHECKSUM=1\` to verify it against the checksum published at ${checksumOrigin} instead.`
+    );
+  }
+
+  const url = `${checksumOrigin.replace(/\/+$/, '')}/${filename}`;
+  debug(`No checksum for ${filename} in the manifest, fetching ${url}.sha256`);
+  let sha256: string;
+  try {
+    const body = await fetchSdkFile(`${url}.sha256`);
+    const text = (await streamToBuffer(body)).toString('utf8');
+    sha256 = text.trim().split(/\s/)[0];
+  } catch (err) {
+    throw new Error(
+      `Cannot verify ${filename}: no checksum in the manifest and ${url}.sha256 is not available (${err})`
+    );
+  }
+  if (!/^[0-9a-f]{64}$/i.test(sha256)) {
+    throw new Error(`Cannot verify ${filename}: invalid checksum "${sha256}"`);
+  }
+  return sha256.toLowerCase();
+}
+
+/**
+ * Downloads the Go SDK from `mirror`, verifies its checksum and installs it
+ * to `dest`.
+ */
+export async function download({
+  dest,
+  version,
+  mirror,
+  checksums,
+  checksumOrigin = GO_SDK_URL,
+  fetchChecksum = false,
+}: {
+  dest: string;
+  version: string;
+  /**
+   * The base URL or local directory of the SDK archives (`GO_SDK_MIRROR`),
+   * defaults to https://dl.google.com/go
+   */
+  mirror?: string;
+  checksums?: Record<string, string>;
+  /**
+   * The base URL of the `.sha256` files of the archives that are not in the
+   * manifest, defaults to https://dl.google.com/go
+   */
+  checksumOrigin?: string;
+  /**
+   * Whether archives that are not in the manifest are verified against the
+   * `.sha256` file at `checksumOrigin` (`GO_SDK_FETCH_CHECKSUM`)
+   */
+  fetchChecksum?: boolean;
+}) {
+  const { filename, url } = getGoUrl(version, mirror);
+  const isInstalled = () => pathExists(join(dest, 'bin'));
+  const wasInstalled = await isInstalled();
+  console.log(`Downloading go: ${url}`);
+  const body = await fetchSdkFile(url);
 
   debug(`Installing go ${version} to ${dest}`);
 
-  await remove(dest);
-  await mkdirp(dest);
+  // parallel builds may install the same version, so it is extracted next to
+  // `dest` and then moved in place
+  const installDir = `${dest}.${process.pid}.tmp`;
+  const archive = `${installDir}-${filename}`;
+  await remove(installDir);
+  await mkdirp(installDir);
+  try {
+    // the archive is verified before anything is extracted from it
+    const hash = createHash('sha256');
+    await streamPipeline(
+      body,
+      new Transform({
+        transform(chunk, _encoding, callback) {
+          hash.update(chunk);
+          callback(null, chunk);
+        },
+      }),
+      createWriteStream(archive)
+    );
+    const expected = await getGoChecksum(
+      filename,
+      checksums || (await loadGoChecksums()),
+      checksumOrigin,
+      fetchChecksum
+    );
+    const actual = hash.digest('hex');
+    if (actual !== expected) {
+      throw new Error(
+        `Checksum mismatch for ${url}: expected ${expected}, got ${actual}`
+      );
+    }
 
-  if (/\.zip$/.test(filename)) {
-    const zipFile = join(tmpdir(), filename);
-    try {
-      await streamPipeline(res.body, createWriteStream(zipFile));
-      const zip = await yauzl.open(zipFile);
-      let entry = await zip.readEntry();
-      while (entry) {
-        const fileName = entry.fileName.split('/').slice(1).join('/');
-
-        if (fileName) {
-          const destPath = join(dest, fileName);
-
-          if (/\/$/.test(fileName)) {
-            await mkdirp(destPath);
-          } else {
-            const [entryStream] = await Promise.all([
-              entry.openReadStream(),
-              mkdirp(dirname(destPath)),
-            ]);
-            const out = createWriteStream(destPath);
-            await streamPipeline(entryStream, out);
-          }
-        }
+    await extract(archive, installDir);
+    if (!wasInstalled && (await isInstalled())) {
+      debug(`Go ${version} was installed to ${dest} by a parallel build`);
+      return;
+    }
+    await remove(dest);
+    await move(installDir, dest);
+  } finally {
+    await remove(installDir);
+    await remove(archive);
+  }
+}
 
-        entry = await zip.readEntry();
+/**
+ * Extracts a Go archive, stripping its top-level `go` directory.
+ */
+async function extract(archive: string, dest: string): Promise<void> {
+  if (/\.zip$/.test(archive)) {
+    const zip = await yauzl.open(archive);
+    let entry = await zip.readEntry();
+    while (entry) {
+      const fileName = entry.fileName.split('/').slice(1).join('/');
+
+      if (fileName) {
+        const destPath = join(dest, fileName);
+
+        if (/\/$/.test(fileName)) {
+          await mkdirp(destPath);
+        } else {
+          const [entryStream] = await Promise.all([
+            entry.openReadStream(),
+            mkdirp(dirname(destPath)),
+          ]);
+          const out = createWriteStream(destPath);
+          await streamPipeline(entryStream, out);
+        }
       }
-    } finally {
-      await remove(zipFile);
+
+      entry = await zip.readEntry();
     }
+    await zip.close();
     return;
   }
 
-  await new Promise((resolve, reject) => {
-    res.body
-      .on('error', reject)
-      .pipe(tar.extract({ cwd: dest, strip: 1 }))
-      .on('error', reject)
-      .on('finish', resolve);
-  });
+  await tar.extract({ file: archive, cwd: dest, strip: 1 });
+}
+
+/**
+ * Finds an executable in the directories of the given `PATH`.
+ *
+ * @param name The name of the executable, without extension
+ * @param path The `PATH` to search
+ * @returns The absolute path to the executable, or `undefined` if not found
+ */
+export async function lookPath(
+  name: string,
+  path = process.env.PATH
+): Promise<string | undefined> {
+  for (const dir of (path || '').split(delimiter)) {
+    if (!dir) {
+      continue;
+    }
+    const file = join(dir, `${name}${OUT_EXTENSION}`);
+    if (await pathExists(file)) {
+      return file;
+    }
+  }
+  return undefined;
 }
 
 const goVersionRegExp = /(\d+)\.(\d+)(?:\.(\d+))?/;
@@ -430,36 +921,6 @@ interface GoVersions {
   toolchain?: string;
 }
 
-/**
- * Attempts to parse the preferred Go version from the `go.mod` file.
- *
- * @param modulePath The directory containing the `go.mod` file
- * @returns
- */
-async function parseGoModVersionFromModule(
-  modulePath: string
-): Promise<GoVersions | undefined> {
-  let version: GoVersions | undefined;
-  const file = join(modulePath, 'go.mod');
-
-  try {
-    const content = await readFile(file, 'utf8');
-    version = parseGoModVersion(content);
-    if (!version) {
-      console.log(`Warning: Unknown Go version in ${file}`);
-    }
-  } catch (err: any) {
-    if (typeof err === 'object' && err.code === 'ENOENT') {
-      debug(`File not found: ${file}`);
-      return undefined;
-    } else {
-      throw err;
-    }
-  }
-
-  return version;
-}
-
 /**
  * Attempts to parse the preferred Go version from the `go.mod` file.
  *
@@ -489,15 +950,332 @@ export function parseGoModVersion(content: string): GoVersions | undefined {
         toolchain,
       };
     }
-    const full = versionMap.get(`${major}.${minor}`);
-    if (full) {
-      return {
-        go: full,
-        toolchain,
-      };
-    }
+    return {
+      go: resolveGoVersion(`${major}.${minor}`),
+      toolchain,
+    };
   }
   const err = new GoError(`Unsupported Go version ${major}.${minor}`);
   err.code = 'ERR_UNSUPPORTED_GO_VERSION';
   throw err;
 }
+
+/**
+ * Resolves a Go language version like `1.22`, or its initial release
+ * `1.22.0`, to the release to install, the latest patch release in the
+ * version map. Versions newer than the version map resolve to their initial
+ * release, e.g. `1.24.0`.
+ *
+ * @param version A Go version, e.g. `1.22`, `1.22rc1` or `1.22.3`
+ * @throws GoError If the go version is not supported
+ */
+function resolveGoVersion(version: string): string {
+  const release = /^(\d+\.\d+)(?:\.0)?$/.exec(version);
+  const full = release && versionMap.get(release[1]);
+  if (full) {
+    return full;
+  }
+  const matches = /^(\d+)\.(\d+)$/.exec(version);
+  if (!matches) {
+    return version;
+  }
+  const newest = Array.from(versionMap.keys())[0];
+  if (compareGoVersions(version, newest) > 0) {
+    return `${version}.0`;
+  }
+  const err = new GoError(`Unsupported Go version ${version}`);
+  err.code = 'ERR_UNSUPPORTED_GO_VERSION';
+  throw err;
+}
+
+/**
+ * Parses the `use` directives of a `go.work` file.
+ *
+ * @returns The paths of the modules used by the workspace
+ */
+export function parseGoWorkUse(contents: string): string[] {
+  const paths: string[] = [];
+  const addPath = (path: string) => {
+    path = path.replace(/\/\/.*$/, '').trim();
+    if (path) {
+      paths.push(path);
+    }
+  };
+
+  // find grouped paths
+  const multiRE = /use\s*\(([^)]+)/g;
+  let match = multiRE.exec(contents);
+  while (match) {
+    if (match[1]) {
+      for (const line of match[1].split(/\r?\n/)) {
+        addPath(line);
+      }
+    }
+    match = multiRE.exec(contents);
+  }
+
+  // find single paths
+  const singleRE = /use\s+(?!\()(.+)/g;
+  match = singleRE.exec(contents);
+  while (match) {
+    addPath(match[1]);
+    match = singleRE.exec(contents);
+  }
+  return paths;
+}
+
+/**
+ * Attempts to find the `go.work` file. It will stop once it hits the
+ * `workPath`.
+ * @param goWorkDir The directory under the `wordPath` to start searching.
+ * @param workPath The project root to stop looking for the file.
+ * @returns The path to the `go.work` file or `undefined`.
+ */
+export async function findGoWorkFile(goWorkDir: string, workPath: string) {
+  while (!(await pathExists(join(goWorkDir, 'go.work')))) {
+    if (goWorkDir === workPath || goWorkDir === dirname(goWorkDir)) {
+      return;
+    }
+    goWorkDir = dirname(goWorkDir);
+  }
+  return join(goWorkDir, 'go.work');
+}
+
+interface GoRequirement {
+  /**
+   * The minimum Go version, e.g. `1.22`
+   */
+  minimum: string;
+  /**
+   * The Go release to install for it, e.g. `1.22.8`
+   */
+  version: string;
+  /**
+   * The file the requirement is from
+   */
+  source: string;
+}
+
+/**
+ * Reads the Go version required by the `go.work` that applies to the module,
+ * which is the newest of the `go` and `toolchain` lines of the `go.work` and
+ * the `go` lines of the modules it uses, or else by the module's `go.mod`.
+ * Like in workspace mode, the `toolchain` lines of the modules are ignored.
+ *
+ * A `go` line resolves to the latest patch release of its version, while a
+ * `toolchain` line asks for its exact release.
+ *
+ * @param modulePath The directory containing the `go.mod` file, if any
+ * @param rootPath The directory to stop looking for a `go.work` file
+ * @param env The environment, for `GOWORK`
+ */
+export async function getGoRequirement(
+  modulePath: string | undefined,
+  rootPath: string,
+  env: Env
+): Promise<GoRequirement | undefined> {
+  const minimums: (string | undefined)[] = [];
+  const toolchains: (string | undefined)[] = [];
+  const addMinimum = async (file: string, withToolchain: boolean) => {
+    const content = await readFile(file, 'utf8').catch(() => '');
+    // validates the version
+    parseGoModVersion(content);
+    const go = /^\s*go\s+(\d+\.\d+(?:\.\d+)?)\s*(?:\/\/.*)?$/m.exec(content);
+    minimums.push(go ? go[1] : undefined);
+    if (withToolchain) {
+      const toolchain =
+        /^\s*toolchain\s+go(\d+\.\d+(?:\.\d+|(?:beta|rc)\d+)?)\s*(?:\/\/.*)?$/m.exec(
+          content
+        );
+      toolchains.push(toolchain ? toolchain[1] : undefined);
+    }
+  };
+
+  let source: string | undefined;
+  const goWork =
+    env.GOWORK === 'off'
+      ? undefined
+      : env.GOWORK || (await findGoWorkFile(modulePath || rootPath, rootPath));
+  if (goWork && (await pathExists(goWork))) {
+    source = goWork;
+    await addMinimum(goWork, true);
+    const contents = await readFile(goWork, 'utf8');
+    for (const use of parseGoWorkUse(contents)) {
+      await addMinimum(join(dirname(goWork), use, 'go.mod'), false);
+    }
+  } else if (modulePath) {
+    source = join(modulePath, 'go.mod');
+    await addMinimum(source, true);
+  }
+
+  const toolchain = maxGoVersion(...toolchains);
+  const minimum = maxGoVersion(...minimums, toolchain);
+  if (!source || !minimum) {
+    return undefined;
+  }
+  const version =
+    toolchain && compareGoVersions(toolchain, minimum) === 0
+      ? toolchain
+      : resolveGoVersion(minimum);
+  return { minimum, version, source };
+}
+
+/**
+ * Collects the files matching the `includeFiles` config, relative to the
+ * entrypoint directory. They are placed next to the `bootstrap` executable,
+ * which is also the working directory of the function.
+ */
+export async function getIncludedFiles(
+  config: Config | undefined,
+  entrypointDirname: string
+): Promise<Files> {
+  const includedFiles: Files = {};
+  if (config && config.includeFiles) {
+    const patterns = Array.isArray(config.includeFiles)
+      ? config.includeFiles
+      : [config.includeFiles];
+    for (const pattern of patterns) {
+      const fsFiles = await glob(pattern, entrypointDirname);
+      for (const [assetName, asset] of Object.entries(fsFiles)) {
+        includedFiles[assetName] = asset;
+      }
+    }
+  }
+  return includedFiles;
+}
+
+/**
+ * Attempts to find a `go.mod` starting in the entrypoint directory and
+ * scanning up the directory tree.
+ * @param entrypointDir The entrypoint directory (e.g. `/path/to/project/api`)
+ * @param workPath  The work path (e.g. `/path/to/project`)
+ * @returns The absolute path to the `go.mod` and a flag if the `go.mod` is in
+ * the work path root
+ */
+export async function findGoModPath(entrypointDir: string, workPath: string) {
+  let goModPath: string | undefined = undefined;
+  let isGoModInRootDir = false;
+  let dir = entrypointDir;
+
+  while (!isGoModInRootDir) {
+    isGoModInRootDir = dir === workPath;
+    const goMod = join(dir, 'go.mod');
+    if (await pathExists(goMod)) {
+      goModPath = goMod;
+      debug(`Found ${goModPath}"`);
+      break;
+    }
+    dir = dirname(dir);
+  }
+
+  return {
+    goModPath,
+    isGoModInRootDir,
+  };
+}
+
+/**
+ * Writes a `go.mod` file in the specified directory. If a `go.mod` file
+ * exists, then update the module name and any relative `replace` statements,
+ * otherwise write the minimum module name.
+ * @param goModPath The path to the `go.mod`, or `undefined` if not found
+ * @param destDir The directory to write the `go.mod` to
+ * @param packageName The module name to inject into the `go.mod`
+ * @param stagePath The staging tree the `go.mod` is in, if it was staged
+ * @param workPath The work path the staging tree was copied from, which
+ * relative `replace` paths outside of the staging tree are resolved against
+ */
+export async function writeGoMod({
+  destDir,
+  goModPath,
+  packageName,
+  stagePath,
+  workPath,
+}: {
+  destDir: string;
+  goModPath?: string;
+  packageName: string;
+  stagePath?: string;
+  workPath?: string;
+}) {
+  let contents = `module ${packageName}`;
+
+  if (goModPath) {
+    const goModRelPath = relative(destDir, dirname(goModPath));
+    const goModContents = await readFile(goModPath, 'utf-8');
+
+    contents = goModContents
+      .replace(/^module\s+.+$/m, contents)
+      .replace(
+        /^(replace .+=>\s*)(.+)$/gm,
+        (orig, replaceStmt, replacePath) => {
+          if (replacePath.startsWith('.')) {
+            const outsidePath =
+              stagePath && workPath
+                ? getPathOutsideStage(
+                    dirname(goModPath),
+                    replacePath,
+                    stagePath,
+                    workPath
+                  )
+                : undefined;
+            return (
+              replaceStmt + (outsidePath || join(goModRelPath, replacePath))
+            );
+          }
+          return orig;
+        }
+      );
+
+    // get the module name, then add the 'replace' mapping if it doesn't
+    // already exist
+    const matches = goModContents.match(/module\s+(.+)/);
+    const moduleName = matches ? matches[1] : null;
+    if (moduleName) {
+      let relPath = normalize(goModRelPath);
+      if (!relPath.endsWith('/')) {
+        relPath += '/';
+      }
+
+      const requireRE = new RegExp(`require\\s+${moduleName}`);
+      const requireGroupRE = new RegExp(
+        `require\\s*\\(.*${moduleName}.*\\)`,
+        's'
+      );
+      if (!requireRE.test(contents) && !requireGroupRE.test(contents)) {
+        contents += `require ${moduleName} v0.0.0-unpublished\n`;
+      }
+
+      const replaceRE = new RegExp(`replace.+=>\\s+${relPath}(\\s|$)`);
+      if (!replaceRE.test(contents)) {
+        contents += `replace ${moduleName} => ${relPath}\n`;
+      }
+    }
+  }
+
+  const destGoModPath = join(destDir, 'go.mod');
+  debug(`Writing ${destGoModPath}`);
+  // console.log(contents);
+  await writeFile(destGoModPath, contents, 'utf-8');
+}
+
+/**
+ * Resolves a relative path of a `go.mod` or `go.work` in the staging tree that
+ * points outside of it against the work path, where it exists.
+ * @param dir The directory of the `go.mod` or `go.work`
+ * @param path The relative path
+ * @param stagePath The staging tree
+ * @param workPath The work path the staging tree was copied from
+ * @returns The absolute path, or `undefined` if it is within the staging tree
+ */
+export function getPathOutsideStage(
+  dir: string,
+  path: string,
+  stagePath: string,
+  workPath: string
+): string | undefined {
+  const stageRelPath = relative(stagePath, join(dir, path));
+  return stageRelPath.startsWith('..')
+    ? join(workPath, stageRelPath)
+    : undefined;
+}
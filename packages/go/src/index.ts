@@ -1,30 +1,27 @@
-import execa from 'execa';
-import retry from 'async-retry';
-import { homedir, tmpdir } from 'os';
-import { spawn } from 'child_process';
-import { Readable } from 'stream';
-import once from '@tootallnate/once';
-import { basename, dirname, join, normalize, posix, relative } from 'path';
+import {
+  basename,
+  dirname,
+  isAbsolute,
+  join,
+  normalize,
+  posix,
+  relative,
+  resolve,
+} from 'path';
 import {
   readFile,
   writeFile,
   lstat,
   pathExists,
-  mkdirp,
   move,
   readlink,
   remove,
-  rmdir,
-  readdir,
   unlink,
-  copy,
 } from 'fs-extra';
 import {
   BuildOptions,
   Files,
   PrepareCacheOptions,
-  StartDevServerOptions,
-  StartDevServerResult,
   glob,
   download,
   Lambda,
@@ -34,18 +31,49 @@ import {
   cloneEnv,
   getProvidedRuntime,
 } from '@vercel/build-utils';
-
-const TMP = tmpdir();
+import type { Env } from '@vercel/build-utils';
 
 import {
+  localBuildCacheDir,
   localCacheDir,
+  localModCacheDir,
   createGo,
+  findGoModPath,
+  findGoWorkFile,
   getAnalyzedEntrypoint,
+  getIncludedFiles,
+  getPathOutsideStage,
   GoWrapper,
+  isOfflineBuild,
+  parseGoWorkUse,
+  writeGoMod,
   OUT_EXTENSION,
+  PGO_FILENAME,
 } from './go-helpers';
+import { getBuildChecks, runBuildChecks } from './checks';
+import {
+  getGenerateInputs,
+  isGenerateEnabled,
+  runGoGenerate,
+} from './generate';
+import { initPrivateModules } from './private-modules';
+import {
+  GO_CACHE_MAX_SIZE,
+  getBuildCacheEntry,
+  getModCacheEntry,
+  getOutputCacheEntry,
+  getOutputCacheKey,
+  localOutputCacheDir,
+  pruneCache,
+  restoreCachedOutput,
+  saveCachedOutput,
+} from './cache';
 
 export { shouldServe };
+export { startDevServer } from './dev-server';
+
+// The module of the package that `main.go` starts the handler with
+const GO_BRIDGE_MODULE = 'github.com/vercel/go-bridge';
 
 // in order to allow the user to have `main.go`,
 // we need our `main.go` to be called something else
@@ -53,24 +81,6 @@ const MAIN_GO_FILENAME = 'main__vc__go__.go';
 
 const HANDLER_FILENAME = `bootstrap${OUT_EXTENSION}`;
 
-interface PortInfo {
-  port: number;
-}
-
-// Initialize private git repo for Go Modules
-async function initPrivateGit(credentials: string) {
-  const gitCredentialsPath = join(homedir(), '.git-credentials');
-
-  await execa('git', [
-    'config',
-    '--global',
-    'credential.helper',
-    `store --file ${gitCredentialsPath}`,
-  ]);
-
-  await writeFile(gitCredentialsPath, credentials);
-}
-
 /**
  * Since `go build` does not support files that begin with a square bracket,
  * we must rename to something temporary to support Path Segments.
@@ -90,23 +100,6 @@ function getRenamedEntrypoint(entrypoint: string): string | undefined {
   return undefined;
 }
 
-type UndoFileAction = {
-  from: string;
-  to: string | undefined;
-};
-
-type UndoFunctionRename = {
-  fsPath: string;
-  from: string;
-  to: string;
-};
-
-type UndoActions = {
-  fileActions: UndoFileAction[];
-  directoryCreation: string[];
-  functionRenames: UndoFunctionRename[];
-};
-
 export const version = 3;
 
 export async function build({
@@ -116,30 +109,36 @@ export async function build({
   workPath,
   meta = {},
 }: BuildOptions) {
+  // the sources are staged in a separate tree, where the entrypoint is
+  // renamed and moved and the `go.mod` rewritten, so that the `workPath` is
+  // never modified, even when it is the user's checkout (`meta.skipDownload`)
   const goPath = await getWriteableDirectory();
-  const srcPath = join(goPath, 'src', 'lambda');
-  const downloadPath = meta.skipDownload ? workPath : srcPath;
-  await download(files, downloadPath, meta);
-
-  // keep track of file system actions we need to undo
-  // the keys "from" and "to" refer to what needs to be done
-  // in order to undo the action, not what the original action was
-  const undo: UndoActions = {
-    fileActions: [],
-    directoryCreation: [],
-    functionRenames: [],
-  };
+  const stagePath = join(goPath, 'src', 'lambda');
+  await download(files, stagePath);
 
   const env = cloneEnv(process.env, meta.env, {
     GOARCH: 'amd64',
     GOOS: 'linux',
   });
 
-  try {
-    if (env.GIT_CREDENTIALS) {
-      debug('Initialize Git credentials...');
-      await initPrivateGit(env.GIT_CREDENTIALS);
+  // the build and module caches are persisted by `prepareCache`, unless
+  // the user points them elsewhere. Both are safe for concurrent builds.
+  // Only set for `build()`, so `vercel dev` keeps using the user's caches.
+  env.GOCACHE = env.GOCACHE || join(workPath, localBuildCacheDir);
+  if (!env.GOMODCACHE) {
+    env.GOMODCACHE = join(workPath, localModCacheDir);
+    // modules are extracted read-only, which keeps `rm -rf .vercel` from
+    // removing a module cache inside the project
+    if (!/(^|\s)-modcacherw\b/.test(env.GOFLAGS || '')) {
+      env.GOFLAGS = `${env.GOFLAGS || ''} -modcacherw`.trim();
     }
+  }
+
+  // credentials for private modules, which are removed after the build
+  let cleanupPrivateModules = async () => {};
+
+  try {
+    cleanupPrivateModules = await initPrivateModules(env);
 
     if (env.GO111MODULE) {
       console.log(`\nManually assigning 'GO111MODULE' is not recommended.
@@ -153,32 +152,88 @@ export async function build({
   `);
     }
 
-    const originalEntrypointAbsolute = join(workPath, entrypoint);
+    // `includeFiles` are read from the `workPath`, since the entrypoint may
+    // be moved within the staging tree
+    const includedFiles = await getIncludedFiles(
+      config,
+      join(workPath, dirname(entrypoint))
+    );
+
+    const checks = getBuildChecks(config);
+    const generate = isGenerateEnabled(config);
+
+    const originalEntrypoint = entrypoint;
     const renamedEntrypoint = getRenamedEntrypoint(entrypoint);
     if (renamedEntrypoint) {
-      await move(join(workPath, entrypoint), join(workPath, renamedEntrypoint));
-      undo.fileActions.push({
-        to: join(workPath, entrypoint),
-        from: join(workPath, renamedEntrypoint),
-      });
+      await move(
+        join(stagePath, entrypoint),
+        join(stagePath, renamedEntrypoint)
+      );
       entrypoint = renamedEntrypoint;
     }
 
-    const entrypointAbsolute = join(workPath, entrypoint);
+    const entrypointAbsolute = join(stagePath, entrypoint);
     const entrypointDirname = dirname(entrypointAbsolute);
 
     const { goModPath, isGoModInRootDir } = await findGoModPath(
       entrypointDirname,
-      workPath
+      stagePath
     );
 
-    if (!goModPath && (await pathExists(join(workPath, 'vendor')))) {
+    if (!goModPath && (await pathExists(join(stagePath, 'vendor')))) {
       throw new Error('`go.mod` is required to use a `vendor` directory.');
     }
 
+    // the `go.work` of the module is used like it is locally, and any other
+    // `go.work` in the staging tree is ignored
+    const goWorkPath = goModPath
+      ? await stageGoWork({ env, goModPath, stagePath, workPath })
+      : undefined;
+    env.GOWORK = goWorkPath || 'off';
+    const originalGoWorkPath = goWorkPath
+      ? join(workPath, relative(stagePath, goWorkPath))
+      : undefined;
+
+    // offline builds use the `vendor` directory, or the module cache with the
+    // module graph of the `go.mod` as is
+    const offline = isOfflineBuild(env);
+    if (offline) {
+      debug('Building offline');
+      env.GOPROXY = 'off';
+      if (goModPath && !/(^|\s)-mod=/.test(env.GOFLAGS || '')) {
+        // a workspace is vendored next to its `go.work`
+        const vendored = await pathExists(
+          join(dirname(goWorkPath || goModPath), 'vendor', 'modules.txt')
+        );
+        const mod = vendored ? 'vendor' : 'readonly';
+        env.GOFLAGS = `${env.GOFLAGS || ''} -mod=${mod}`.trim();
+      }
+    }
+
+    const modulePath = goModPath ? dirname(goModPath) : undefined;
+    const go = await createGo({
+      modulePath,
+      opts: {
+        cwd: entrypointDirname,
+        env,
+      },
+      rootPath: stagePath,
+      workPath,
+    });
+
+    if (generate && !modulePath) {
+      throw new Error('`goGenerate` requires a `go.mod`');
+    }
+
+    // the entrypoint is analyzed in the `workPath`, where the modules of
+    // relative `replace` directives outside of the staging tree exist too
+    const originalModulePath = goModPath
+      ? join(workPath, relative(stagePath, dirname(goModPath)))
+      : undefined;
     const analyzed = await getAnalyzedEntrypoint({
-      entrypoint,
-      modulePath: goModPath ? dirname(goModPath) : undefined,
+      entrypoint: originalEntrypoint,
+      goWorkPath: originalGoWorkPath,
+      modulePath: originalModulePath,
       workPath,
     });
 
@@ -189,68 +244,119 @@ export async function build({
       throw new Error('Please change `package main` to `package handler`');
     }
 
-    // rename the Go handler function name in the original entrypoint file
     const originalFunctionName = analyzed.functionName;
     const handlerFunctionName = getNewHandlerFunctionName(
       originalFunctionName,
       entrypoint
     );
-    await renameHandlerFunction(
-      entrypointAbsolute,
-      originalFunctionName,
-      handlerFunctionName
-    );
-    undo.functionRenames.push({
-      fsPath: originalEntrypointAbsolute,
-      from: handlerFunctionName,
-      to: originalFunctionName,
-    });
-
-    const includedFiles: Files = {};
-    if (config && config.includeFiles) {
-      const patterns = Array.isArray(config.includeFiles)
-        ? config.includeFiles
-        : [config.includeFiles];
-      for (const pattern of patterns) {
-        const fsFiles = await glob(pattern, entrypointDirname);
-        for (const [assetName, asset] of Object.entries(fsFiles)) {
-          includedFiles[assetName] = asset;
-        }
-      }
-    }
-
-    const modulePath = goModPath ? dirname(goModPath) : undefined;
-    const go = await createGo({
-      modulePath,
-      opts: {
-        cwd: entrypointDirname,
-        env,
-      },
-      workPath,
-    });
 
     const outDir = await getWriteableDirectory();
+    const pgo = await findPgoProfile(entrypointDirname);
+    const runtime = await getProvidedRuntime();
     const buildOptions: BuildHandlerOptions = {
-      downloadPath,
       entrypoint,
       entrypointAbsolute,
       entrypointDirname,
       go,
       goModPath,
+      goWorkPath,
       handlerFunctionName,
       isGoModInRootDir,
+      offline,
       outDir,
       packageName,
-      undo,
+      pgo,
+      stagePath,
+      workPath,
     };
 
-    if (packageName === 'main') {
-      await buildHandlerAsPackageMain(buildOptions);
+    // an older `analyze` binary does not report the import graph. Without a
+    // `go.mod`, the dependencies are fetched with `go get` at their latest
+    // versions, which the key cannot capture, so the output is not cached
+    let outputCacheDir: string | undefined;
+    if (analyzed.watch && originalModulePath) {
+      const key = await getOutputCacheKey({
+        workPath,
+        // the import graph of the generated files is not known before
+        // `go generate` runs, so any file it may read is keyed instead, and
+        // a cached output skips both `go generate` and the build
+        files: generate
+          ? Array.from(
+              new Set([
+                ...analyzed.watch,
+                ...(await getGenerateInputs(
+                  originalModulePath,
+                  originalGoWorkPath
+                )),
+              ])
+            )
+          : analyzed.watch,
+        goWorkPath: originalGoWorkPath,
+        modulePath: originalModulePath,
+        goVersion: (await go.version()).version,
+        env,
+        includedFiles,
+        runtime,
+        pgo,
+        checks,
+        generate,
+      });
+      outputCacheDir = join(workPath, localOutputCacheDir, key);
+    }
+
+    if (outputCacheDir && (await restoreCachedOutput(outputCacheDir, outDir))) {
+      console.log(`Using cached build of "${originalEntrypoint}"`);
     } else {
-      await buildHandlerWithGoMod(buildOptions);
+      // the generated files exist in the staging tree only
+      let generated = false;
+      if (generate && modulePath) {
+        const { written, deleted } = await runGoGenerate({
+          go,
+          modulePath,
+          toolsPath: join(goPath, 'bin'),
+          env,
+        });
+        generated = written.length > 0 || deleted.length > 0;
+      }
+
+      if (checks.vet || checks.test) {
+        // the checks run on the sources as they are in the `workPath`, where
+        // the modules of relative `replace` directives outside of the staging
+        // tree exist too, unless they need the generated files
+        if (!originalModulePath) {
+          throw new Error('`goVet` and `goTest` require a `go.mod`');
+        }
+        const checksGo = generated
+          ? go
+          : await createGo({
+              modulePath: originalModulePath,
+              opts: {
+                cwd: join(workPath, dirname(originalEntrypoint)),
+                env: { ...env, GOWORK: originalGoWorkPath || 'off' },
+              },
+              workPath,
+            });
+        await runBuildChecks(checksGo, checks, env);
+      }
+
+      // rename the Go handler function name in the staged entrypoint file,
+      // after the checks that may run on the staged sources
+      await renameHandlerFunction(
+        entrypointAbsolute,
+        originalFunctionName,
+        handlerFunctionName
+      );
+
+      if (packageName === 'main') {
+        await buildHandlerAsPackageMain(buildOptions);
+      } else {
+        await buildHandlerWithGoMod(buildOptions);
+      }
+      if (outputCacheDir) {
+        await saveCachedOutput(outDir, outputCacheDir);
+      }
     }
 
-    const runtime = await getProvidedRuntime();
     const lambda = new Lambda({
       files: { ...(await glob('**', outDir)), ...includedFiles },
       handler: HANDLER_FILENAME,
@@ -267,29 +373,37 @@ export async function build({
 
     throw error;
   } finally {
-    try {
-      await cleanupFileSystem(undo);
-    } catch (error) {
-      if (error instanceof Error) {
-        console.error(`Build cleanup failed: ${error.message}`);
-      }
-      debug('Cleanup Error: ' + error);
-    }
+    await cleanupPrivateModules();
+    await remove(goPath);
   }
 }
 
+/**
+ * Returns the path to the `default.pgo` profile in the entrypoint directory,
+ * if any.
+ */
+async function findPgoProfile(
+  entrypointDirname: string
+): Promise<string | undefined> {
+  const pgo = join(entrypointDirname, PGO_FILENAME);
+  return (await pathExists(pgo)) ? pgo : undefined;
+}
+
 type BuildHandlerOptions = {
-  downloadPath: string;
   entrypoint: string;
   entrypointAbsolute: string;
   entrypointDirname: string;
   go: GoWrapper;
   goModPath?: string;
+  goWorkPath?: string;
   handlerFunctionName: string;
   isGoModInRootDir: boolean;
+  offline: boolean;
   outDir: string;
   packageName: string;
-  undo: UndoActions;
+  pgo?: string;
+  stagePath: string;
+  workPath: string;
 };
 
 /**
@@ -297,17 +411,20 @@ type BuildHandlerOptions = {
  * does not exist, a default one will be used.
  */
 async function buildHandlerWithGoMod({
-  downloadPath,
   entrypoint,
   entrypointAbsolute,
   entrypointDirname,
   go,
   goModPath,
+  goWorkPath,
   handlerFunctionName,
   isGoModInRootDir,
+  offline,
   outDir,
   packageName,
-  undo,
+  pgo,
+  stagePath,
+  workPath,
 }: BuildHandlerOptions): Promise<void> {
   debug(
     `Building Go handler as package "${packageName}" (with${
@@ -315,30 +432,7 @@ async function buildHandlerWithGoMod({
     } go.mod)`
   );
 
-  let goModDirname: string | undefined;
-
-  if (goModPath !== undefined) {
-    goModDirname = dirname(goModPath);
-
-    // first we backup the original
-    const backupFile = join(goModDirname, `__vc_go.mod.bak`);
-    await copy(goModPath, backupFile);
-
-    undo.fileActions.push({
-      to: goModPath,
-      from: backupFile,
-    });
-
-    const goSumPath = join(goModDirname, 'go.sum');
-    const isGoSumExists = await pathExists(goSumPath);
-    if (!isGoSumExists) {
-      // remove the `go.sum` file that will be generated as well
-      undo.fileActions.push({
-        to: undefined, // delete
-        from: goSumPath,
-      });
-    }
-  }
+  const goModDirname = goModPath ? dirname(goModPath) : undefined;
 
   const entrypointArr = entrypoint.split(posix.sep);
   let goPackageName = `${packageName}/${packageName}`;
@@ -355,8 +449,8 @@ async function buildHandlerWithGoMod({
 
   let mainGoFile: string;
   if (goModPath && isGoModInRootDir) {
-    debug(`[mod-root] Write main file to ${downloadPath}`);
-    mainGoFile = join(downloadPath, MAIN_GO_FILENAME);
+    debug(`[mod-root] Write main file to ${stagePath}`);
+    mainGoFile = join(stagePath, MAIN_GO_FILENAME);
   } else if (goModDirname && !isGoModInRootDir) {
     debug(`[mod-other] Write main file to ${goModDirname}`);
     mainGoFile = join(goModDirname, MAIN_GO_FILENAME);
@@ -365,19 +459,29 @@ async function buildHandlerWithGoMod({
     mainGoFile = join(entrypointDirname, MAIN_GO_FILENAME);
   }
 
-  await Promise.all([
-    writeEntrypoint(mainGoFile, goPackageName, goFuncName),
-    writeGoMod({
-      destDir: goModDirname ? goModDirname : entrypointDirname,
-      goModPath,
-      packageName,
-    }),
-  ]);
-
-  undo.fileActions.push({
-    to: undefined, // delete
-    from: mainGoFile,
-  });
+  if ((offline || goWorkPath) && goModPath) {
+    // the `go.mod` is used as is, since rewriting it would change the module
+    // graph or break the workspace, so the handler is imported with the
+    // module path of the `go.mod`
+    const goModContents = await readFile(goModPath, 'utf-8');
+    const matches = goModContents.match(/^module\s+"?([^\s"]+)/m);
+    if (!matches) {
+      throw new Error(`Missing module path in "${goModPath}"`);
+    }
+    goPackageName = posix.join(matches[1], relPackagePath || packageName);
+    await writeEntrypoint(mainGoFile, goPackageName, goFuncName);
+  } else {
+    await Promise.all([
+      writeEntrypoint(mainGoFile, goPackageName, goFuncName),
+      writeGoMod({
+        destDir: goModDirname ? goModDirname : entrypointDirname,
+        goModPath,
+        packageName,
+        stagePath,
+        workPath,
+      }),
+    ]);
+  }
 
   // move user go file to folder
   try {
@@ -399,11 +503,6 @@ async function buildHandlerWithGoMod({
       );
 
       await move(entrypointAbsolute, finalDestination);
-      undo.fileActions.push({
-        to: entrypointAbsolute,
-        from: finalDestination,
-      });
-      undo.directoryCreation.push(dirname(finalDestination));
     }
   } catch (err) {
     console.error('Failed to move entry to package folder');
@@ -412,29 +511,46 @@ async function buildHandlerWithGoMod({
 
   let baseGoModPath = '';
   if (goModPath && isGoModInRootDir) {
-    baseGoModPath = downloadPath;
+    baseGoModPath = stagePath;
   } else if (goModPath && !isGoModInRootDir) {
     baseGoModPath = dirname(goModPath);
   } else {
     baseGoModPath = entrypointDirname;
   }
 
-  debug('Tidy `go.mod` file...');
-  try {
-    // ensure go.mod up-to-date
-    await go.mod();
-  } catch (err) {
-    console.error('failed to `go mod tidy`');
-    throw err;
+  const src = [join(baseGoModPath, MAIN_GO_FILENAME)];
+
+  if (offline) {
+    await checkOfflinePackages(go, src);
+  } else if (goWorkPath && goModPath) {
+    // `go mod tidy` ignores the `go.work`, so it cannot resolve the packages
+    // of the other modules of the workspace
+    const goModContents = await readFile(goModPath, 'utf-8');
+    if (!goModContents.includes(GO_BRIDGE_MODULE)) {
+      debug(`Adding ${GO_BRIDGE_MODULE} to the workspace module...`);
+      try {
+        await go.get(`${GO_BRIDGE_MODULE}/go/bridge`);
+      } catch (err) {
+        console.error(`Failed to \`go get ${GO_BRIDGE_MODULE}\``);
+        throw err;
+      }
+    }
+  } else {
+    debug('Tidy `go.mod` file...');
+    try {
+      // ensure go.mod up-to-date
+      await go.mod();
+    } catch (err) {
+      console.error('failed to `go mod tidy`');
+      throw err;
+    }
   }
 
   debug('Running `go build`...');
   const destPath = join(outDir, HANDLER_FILENAME);
 
   try {
-    const src = [join(baseGoModPath, MAIN_GO_FILENAME)];
-
-    await go.build(src, destPath);
+    await go.build(src, destPath, { pgo });
   } catch (err) {
     console.error('failed to `go build`');
     throw err;
@@ -451,8 +567,9 @@ async function buildHandlerAsPackageMain({
   entrypointDirname,
   go,
   handlerFunctionName,
+  offline,
   outDir,
-  undo,
+  pgo,
 }: BuildHandlerOptions): Promise<void> {
   debug('Building Go handler as package "main" (legacy)');
 
@@ -462,35 +579,51 @@ async function buildHandlerAsPackageMain({
     handlerFunctionName
   );
 
-  undo.fileActions.push({
-    to: undefined, // delete
-    from: join(entrypointDirname, MAIN_GO_FILENAME),
-  });
+  const src = [
+    join(entrypointDirname, MAIN_GO_FILENAME),
+    entrypointAbsolute,
+  ].map(file => normalize(file));
 
-  // `go get` will look at `*.go` (note we set `cwd`), parse the `import`s
-  // and download any packages that aren't part of the stdlib
-  debug('Running `go get`...');
-  try {
-    await go.get();
-  } catch (err) {
-    console.error('Failed to `go get`');
-    throw err;
+  if (offline) {
+    await checkOfflinePackages(go, src);
+  } else {
+    // `go get` will look at `*.go` (note we set `cwd`), parse the `import`s
+    // and download any packages that aren't part of the stdlib
+    debug('Running `go get`...');
+    try {
+      await go.get();
+    } catch (err) {
+      console.error('Failed to `go get`');
+      throw err;
+    }
   }
 
   debug('Running `go build`...');
   const destPath = join(outDir, HANDLER_FILENAME);
   try {
-    const src = [
-      join(entrypointDirname, MAIN_GO_FILENAME),
-      entrypointAbsolute,
-    ].map(file => normalize(file));
-    await go.build(src, destPath);
+    await go.build(src, destPath, { pgo });
   } catch (err) {
     console.error('failed to `go build`');
     throw err;
   }
 }
 
+/**
+ * Fails an offline build with all packages that cannot be loaded without the
+ * network, rather than with the first error of `go build`.
+ */
+async function checkOfflinePackages(go: GoWrapper, src: string[]) {
+  debug('Checking the packages are available offline...');
+  const errors = await go.listPackageErrors(src);
+  if (errors.length > 0) {
+    throw new Error(
+      `The following packages are not available offline (\`GO_BUILD_OFFLINE\` is set). Vendor them with \`go mod vendor\` or restore the module cache, noting that \`go.mod\` must also require \`github.com/vercel/go-bridge\`:\n${errors
+        .map(error => `  - ${error}`)
+        .join('\n')}`
+    );
+  }
+}
+
 async function renameHandlerFunction(fsPath: string, from: string, to: string) {
   let fileContents = await readFile(fsPath, 'utf8');
 
@@ -534,103 +667,6 @@ export function getNewHandlerFunctionName(
   return newHandlerName;
 }
 
-/**
- * Remove any temporary files, directories, and file changes.
- */
-async function cleanupFileSystem({
-  fileActions,
-  directoryCreation,
-  functionRenames,
-}: UndoActions) {
-  // we have to undo the actions in reverse order in cases
-  // where one file was moved multiple times, which happens
-  // using files that start with brackets
-  for (const action of fileActions.reverse()) {
-    if (action.to) {
-      await move(action.from, action.to, { overwrite: true });
-    } else {
-      await remove(action.from);
-    }
-  }
-
-  // after files are moved back, we can undo function renames
-  // these reference the original file location
-  for (const rename of functionRenames) {
-    await renameHandlerFunction(rename.fsPath, rename.from, rename.to);
-  }
-
-  const undoDirectoryPromises = directoryCreation.map(async directory => {
-    const contents = await readdir(directory);
-    // only delete an empty directory
-    // if it has contents, either something went wrong during cleanup or this
-    // directory contains project source code that should not be deleted
-    if (!contents.length) {
-      return rmdir(directory);
-    }
-    return undefined;
-  });
-  await Promise.all(undoDirectoryPromises);
-}
-
-/**
- * Attempts to find a `go.mod` starting in the entrypoint directory and
- * scanning up the directory tree.
- * @param entrypointDir The entrypoint directory (e.g. `/path/to/project/api`)
- * @param workPath  The work path (e.g. `/path/to/project`)
- * @returns The absolute path to the `go.mod` and a flag if the `go.mod` is in
- * the work path root
- */
-async function findGoModPath(entrypointDir: string, workPath: string) {
-  let goModPath: string | undefined = undefined;
-  let isGoModInRootDir = false;
-  let dir = entrypointDir;
-
-  while (!isGoModInRootDir) {
-    isGoModInRootDir = dir === workPath;
-    const goMod = join(dir, 'go.mod');
-    if (await pathExists(goMod)) {
-      goModPath = goMod;
-      debug(`Found ${goModPath}"`);
-      break;
-    }
-    dir = dirname(dir);
-  }
-
-  return {
-    goModPath,
-    isGoModInRootDir,
-  };
-}
-
-function isPortInfo(v: any): v is PortInfo {
-  return v && typeof v.port === 'number';
-}
-
-function isReadable(v: any): v is Readable {
-  return v && v.readable === true;
-}
-
-async function copyEntrypoint(entrypoint: string, dest: string): Promise<void> {
-  const data = await readFile(entrypoint, 'utf8');
-
-  // Modify package to `package main`
-  const patched = data.replace(/\bpackage\W+\S+\b/, 'package main');
-
-  await writeFile(join(dest, 'entrypoint.go'), patched);
-}
-
-async function copyDevServer(
-  functionName: string,
-  dest: string
-): Promise<void> {
-  const data = await readFile(join(__dirname, '../dev-server.go'), 'utf8');
-
-  // Populate the handler function name
-  const patched = data.replace('__HANDLER_FUNC_NAME', functionName);
-
-  await writeFile(join(dest, 'vercel-dev-server-main.go'), patched);
-}
-
 async function writeEntrypoint(
   dest: string,
   goPackageName: string,
@@ -647,320 +683,74 @@ async function writeEntrypoint(
 }
 
 /**
- * Writes a `go.mod` file in the specified directory. If a `go.mod` file
- * exists, then update the module name and any relative `replace` statements,
- * otherwise write the minimum module name.
- * @param workPath The work path; required if `goModPath` exists
- * @param goModPath The path to the `go.mod`, or `undefined` if not found
- * @param destDir The directory to write the `go.mod` to
- * @param packageName The module name to inject into the `go.mod`
+ * Finds the `go.work` that applies to the module like `go` does, which is
+ * `GOWORK` relative to the work path or else the nearest one in the module
+ * directory or its parents, and rewrites the relative `use` and `replace`
+ * paths of the staged copy that point outside of the staging tree.
+ * @param env The build environment, for `GOWORK`
+ * @param goModPath The staged `go.mod` of the entrypoint
+ * @param stagePath The staging tree
+ * @param workPath The work path the staging tree was copied from
+ * @returns The path to the staged `go.work`, or `undefined` if there is none
+ * or it does not use the module
  */
-async function writeGoMod({
-  destDir,
+async function stageGoWork({
+  env,
   goModPath,
-  packageName,
+  stagePath,
+  workPath,
 }: {
-  destDir: string;
-  goModPath?: string;
-  packageName: string;
-}) {
-  let contents = `module ${packageName}`;
-
-  if (goModPath) {
-    const goModRelPath = relative(destDir, dirname(goModPath));
-    const goModContents = await readFile(goModPath, 'utf-8');
-
-    contents = goModContents
-      .replace(/^module\s+.+$/m, contents)
-      .replace(
-        /^(replace .+=>\s*)(.+)$/gm,
-        (orig, replaceStmt, replacePath) => {
-          if (replacePath.startsWith('.')) {
-            return replaceStmt + join(goModRelPath, replacePath);
-          }
-          return orig;
-        }
-      );
-
-    // get the module name, then add the 'replace' mapping if it doesn't
-    // already exist
-    const matches = goModContents.match(/module\s+(.+)/);
-    const moduleName = matches ? matches[1] : null;
-    if (moduleName) {
-      let relPath = normalize(goModRelPath);
-      if (!relPath.endsWith('/')) {
-        relPath += '/';
-      }
-
-      const requireRE = new RegExp(`require\\s+${moduleName}`);
-      const requireGroupRE = new RegExp(
-        `require\\s*\\(.*${moduleName}.*\\)`,
-        's'
-      );
-      if (!requireRE.test(contents) && !requireGroupRE.test(contents)) {
-        contents += `require ${moduleName} v0.0.0-unpublished\n`;
-      }
-
-      const replaceRE = new RegExp(`replace.+=>\\s+${relPath}(\\s|$)`);
-      if (!replaceRE.test(contents)) {
-        contents += `replace ${moduleName} => ${relPath}\n`;
-      }
-    }
-  }
-
-  const destGoModPath = join(destDir, 'go.mod');
-  debug(`Writing ${destGoModPath}`);
-  // console.log(contents);
-  await writeFile(destGoModPath, contents, 'utf-8');
-}
-
-/**
- * Attempts to find the `go.work` file. It will stop once it hits the
- * `workPath`.
- * @param goWorkDir The directory under the `wordPath` to start searching.
- * @param workPath The project root to stop looking for the file.
- * @returns The path to the `go.work` file or `undefined`.
- */
-async function findGoWorkFile(goWorkDir: string, workPath: string) {
-  while (!(await pathExists(join(goWorkDir, 'go.work')))) {
-    if (goWorkDir === workPath) {
-      return;
-    }
-    goWorkDir = dirname(goWorkDir);
+  env: Env;
+  goModPath: string;
+  stagePath: string;
+  workPath: string;
+}): Promise<string | undefined> {
+  if (env.GOWORK === 'off') {
+    return undefined;
   }
-  return join(goWorkDir, 'go.work');
-}
-
-/**
- * For simple cases, a `go.work` file is not required. However when a Go
- * program requires source files outside the work path, we need a `go.work` so
- * Go can find the root of the project being built.
- * @param destDir The destination directory to write the `go.work` file.
- * @param workPath The path to the work directory.
- * @param modulePath The path to the directory containing the `go.mod`.
- */
-async function writeGoWork(
-  destDir: string,
-  workPath: string,
-  modulePath?: string
-) {
-  const workspaces = new Set(['.']);
-  const goWorkPath = await findGoWorkFile(modulePath || workPath, workPath);
-  let goVersion: string | undefined;
 
-  if (goWorkPath) {
-    const contents = await readFile(goWorkPath, 'utf-8');
-
-    // Extract go version if present
-    const goVersionMatch = contents.match(/^go\s+(\d+\.\d+(\.\d+)?)/m);
-    if (goVersionMatch) {
-      goVersion = goVersionMatch[1];
-    }
-
-    const addPath = (path: string) => {
-      if (path) {
-        if (path.startsWith('.')) {
-          workspaces.add(relative(destDir, join(workPath, path)));
-        } else {
-          workspaces.add(path);
-        }
-      }
-    };
-
-    // find grouped paths
-    const multiRE = /use\s*\(([^)]+)/g;
-    let match = multiRE.exec(contents);
-    while (match) {
-      if (match[1]) {
-        for (const line of match[1].split(/\r?\n/)) {
-          addPath(line.trim());
-        }
-      }
-      match = multiRE.exec(contents);
-    }
-
-    // find single paths
-    const singleRE = /use\s+(?!\()(.+)/g;
-    match = singleRE.exec(contents);
-    while (match) {
-      addPath(match[1].trim());
-      match = singleRE.exec(contents);
+  let goWorkPath: string | undefined;
+  if (env.GOWORK) {
+    const relPath = relative(workPath, resolve(workPath, env.GOWORK));
+    if (relPath.startsWith('..') || isAbsolute(relPath)) {
+      throw new Error(
+        `\`GOWORK\` must be a path within the project, but is "${env.GOWORK}"`
+      );
     }
-  } else if (modulePath) {
-    workspaces.add(relative(destDir, modulePath));
-
-    // If no existing go.work, try to get version from go.mod
-    const goModPath = join(modulePath, 'go.mod');
-    if (await pathExists(goModPath)) {
-      const goModContents = await readFile(goModPath, 'utf-8');
-      const goVersionMatch = goModContents.match(/^go\s+(\d+\.\d+(\.\d+)?)/m);
-      if (goVersionMatch) {
-        goVersion = goVersionMatch[1];
-      }
+    goWorkPath = join(stagePath, relPath);
+    if (!(await pathExists(goWorkPath))) {
+      throw new Error(`\`GOWORK\` file "${env.GOWORK}" does not exist`);
     }
+  } else {
+    goWorkPath = await findGoWorkFile(dirname(goModPath), stagePath);
   }
-
-  // Construct go.work contents with version directive if available
-  let contents = '';
-  if (goVersion) {
-    contents += `go ${goVersion}\n\n`;
-  }
-
-  contents += `use (\n${Array.from(workspaces)
-    .map(w => `  ${w}\n`)
-    .join('')})\n`;
-  // console.log(contents);
-  await writeFile(join(destDir, 'go.work'), contents, 'utf-8');
-}
-
-export async function startDevServer(
-  opts: StartDevServerOptions
-): Promise<StartDevServerResult> {
-  const { entrypoint, workPath, meta = {} } = opts;
-  const { devCacheDir = join(workPath, '.vercel', 'cache') } = meta;
-  const entrypointDir = dirname(entrypoint);
-
-  // For some reason, if `entrypoint` is a path segment (filename contains `[]`
-  // brackets) then the `.go` suffix on the entrypoint is missing. Fix that here…
-  let entrypointWithExt = entrypoint;
-  if (!entrypoint.endsWith('.go')) {
-    entrypointWithExt += '.go';
-  }
-
-  const tmp = join(devCacheDir, 'go', Math.random().toString(32).substring(2));
-  const tmpPackage = join(tmp, entrypointDir);
-  await mkdirp(tmpPackage);
-
-  const { goModPath } = await findGoModPath(
-    join(workPath, entrypointDir),
-    workPath
-  );
-  const modulePath = goModPath ? dirname(goModPath) : undefined;
-  const analyzed = await getAnalyzedEntrypoint({
-    entrypoint: entrypointWithExt,
-    modulePath,
-    workPath,
-  });
-
-  await Promise.all([
-    copyEntrypoint(entrypointWithExt, tmpPackage),
-    copyDevServer(analyzed.functionName, tmpPackage),
-    writeGoMod({
-      destDir: tmp,
-      goModPath,
-      packageName: analyzed.packageName,
-    }),
-    writeGoWork(tmp, workPath, modulePath),
-  ]);
-
-  const portFile = join(
-    TMP,
-    `vercel-dev-port-${Math.random().toString(32).substring(2)}`
-  );
-
-  const env = cloneEnv(process.env, meta.env, {
-    VERCEL_DEV_PORT_FILE: portFile,
-  });
-
-  const executable = `./vercel-dev-server-go${
-    process.platform === 'win32' ? '.exe' : ''
-  }`;
-
-  // Note: We must run `go build`, then manually spawn the dev server instead
-  // of spawning `go run`. See https://github.com/vercel/vercel/pull/8718 for
-  // more info.
-
-  // build the dev server
-  const go = await createGo({
-    modulePath,
-    opts: {
-      cwd: tmp,
-      env,
-    },
-    workPath,
-  });
-  await go.build('./...', executable);
-
-  // run the dev server
-  debug(`SPAWNING ${executable} CWD=${tmp}`);
-  const child = spawn(executable, [], {
-    cwd: tmp,
-    env,
-    stdio: ['ignore', 'inherit', 'inherit', 'pipe'],
-  });
-
-  child.on('close', async () => {
-    try {
-      await retry(() => remove(tmp));
-    } catch (err: any) {
-      console.error(`Could not delete tmp directory: ${tmp}: ${err}`);
-    }
-  });
-
-  const portPipe = child.stdio[3];
-  if (!isReadable(portPipe)) {
-    throw new Error('File descriptor 3 is not readable');
+  if (!goWorkPath) {
+    return undefined;
   }
 
-  // `dev-server.go` writes the ephemeral port number to FD 3 to be consumed here
-  const onPort = new Promise<PortInfo>(resolve => {
-    portPipe.setEncoding('utf8');
-    portPipe.once('data', d => {
-      resolve({ port: Number(d) });
-    });
-  });
-  const onPortFile = waitForPortFile(portFile);
-  const onExit = once.spread<[number, string | null]>(child, 'exit');
-  const result = await Promise.race([onPort, onPortFile, onExit]);
-  onExit.cancel();
-  onPortFile.cancel();
-
-  if (isPortInfo(result)) {
-    return {
-      port: result.port,
-      pid: child.pid,
-    };
-  } else if (Array.isArray(result)) {
-    // Got "exit" event from child process
-    const [exitCode, signal] = result;
-    const reason = signal ? `"${signal}" signal` : `exit code ${exitCode}`;
-    throw new Error(`\`go run ${entrypointWithExt}\` failed with ${reason}`);
-  } else {
-    throw new Error(`Unexpected result type: ${typeof result}`);
+  const goWorkDir = dirname(goWorkPath);
+  const contents = await readFile(goWorkPath, 'utf-8');
+  const uses = parseGoWorkUse(contents).map(use => resolve(goWorkDir, use));
+  if (!uses.includes(dirname(goModPath))) {
+    debug(`Ignoring ${goWorkPath}, which does not use ${goModPath}`);
+    return undefined;
   }
-}
-
-export interface CancelablePromise<T> extends Promise<T> {
-  cancel: () => void;
-}
-
-function waitForPortFile(portFile: string) {
-  const opts = { portFile, canceled: false };
-  const promise = waitForPortFile_(opts) as CancelablePromise<PortInfo | void>;
-  promise.cancel = () => {
-    opts.canceled = true;
-  };
-  return promise;
-}
 
-async function waitForPortFile_(opts: {
-  portFile: string;
-  canceled: boolean;
-}): Promise<PortInfo | void> {
-  while (!opts.canceled) {
-    await new Promise(resolve => setTimeout(resolve, 100));
-    try {
-      const port = Number(await readFile(opts.portFile, 'ascii'));
-      retry(() => remove(opts.portFile)).catch((err: Error) => {
-        console.error(`Could not delete port file: ${opts.portFile}: ${err}`);
-      });
-      return { port };
-    } catch (err: any) {
-      if (err.code !== 'ENOENT') {
-        throw err;
-      }
+  const staged = contents.replace(
+    /^(\s*(?:use\s+)?|.*=>\s*)(\.\.?(?:\/\S*)?)(?=\s*(?:\/\/.*)?$)/gm,
+    (orig, directive, path) => {
+      const outsidePath = getPathOutsideStage(
+        goWorkDir,
+        path,
+        stagePath,
+        workPath
+      );
+      return outsidePath ? directive + outsidePath : orig;
     }
-  }
+  );
+  debug(`Using ${goWorkPath}`);
+  await writeFile(goWorkPath, staged, 'utf-8');
+  return goWorkPath;
 }
 
 export async function prepareCache({
@@ -985,6 +775,29 @@ export async function prepareCache({
     await move(goGlobalCacheDir, goCacheDir);
   }
 
-  const cache = await glob(`${localCacheDir}/**`, workPath);
+  // The build, module and output caches are pruned to keep restoring them
+  // cheap
+  await pruneCache(
+    join(workPath, localBuildCacheDir),
+    GO_CACHE_MAX_SIZE,
+    getBuildCacheEntry
+  );
+  await pruneCache(
+    join(workPath, localModCacheDir),
+    GO_CACHE_MAX_SIZE,
+    getModCacheEntry
+  );
+  await pruneCache(
+    join(workPath, localOutputCacheDir),
+    GO_CACHE_MAX_SIZE,
+    getOutputCacheEntry
+  );
+
+  const cache = {
+    ...(await glob(`${localCacheDir}/**`, workPath)),
+    ...(await glob(`${localBuildCacheDir}/**`, workPath)),
+    ...(await glob(`${localModCacheDir}/**`, workPath)),
+    ...(await glob(`${localOutputCacheDir}/**`, workPath)),
+  };
   return cache;
 }
@@ -0,0 +1,158 @@
+#!/usr/bin/env node
+import { runLoadTest } from './load-test';
+import type { LoadTestOptions } from './load-test';
+
+const usage = `Usage: vercel-go-load-test [options] <entrypoint>
+
+Runs a load test against the Go function <entrypoint> of the project in the
+current directory, and prints a report with latency percentiles, the error
+rate, allocations per request and peak RSS.
+
+Options:
+  --target <target>         "dev" for the dev server, or "bootstrap" for the
+                            production bootstrap (default: dev)
+  -c, --concurrency <n>     Number of concurrent requests (default: 10)
+  -d, --duration <seconds>  Duration of the load test (default: 10)
+  -X, --method <method>     Method of the request (default: GET)
+  -p, --path <path>         Path of the request, including the query string
+                            (default: /)
+  -H, --header <header>     Header of the request, e.g. "Accept: text/html",
+                            may be repeated
+  --body <body>             Body of the request
+  --json                    Print the result as JSON to stdout
+  -h, --help                Show this help
+`;
+
+export interface LoadTestArgs {
+  options: Omit<LoadTestOptions, 'workPath'>;
+  json: boolean;
+  help: boolean;
+}
+
+function parsePositiveInteger(name: string, value: string): number {
+  const n = Number(value);
+  if (!Number.isInteger(n) || n <= 0) {
+    throw new Error(`Invalid ${name} "${value}", expected a positive integer`);
+  }
+  return n;
+}
+
+/**
+ * Parses the command line arguments of `vercel-go-load-test`.
+ */
+export function parseLoadTestArgs(argv: string[]): LoadTestArgs {
+  const args: LoadTestArgs = {
+    options: { target: 'dev', entrypoint: '', request: {} },
+    json: false,
+    help: false,
+  };
+  const request = args.options.request || {};
+
+  for (let i = 0; i < argv.length; i++) {
+    const arg = argv[i];
+    const value = () => {
+      if (i + 1 >= argv.length) {
+        throw new Error(`Missing value for ${arg}`);
+      }
+      return argv[++i];
+    };
+    switch (arg) {
+      case '--target': {
+        const target = value();
+        if (target !== 'dev' && target !== 'bootstrap') {
+          throw new Error(
+            `Invalid target "${target}", expected "dev" or "bootstrap"`
+          );
+        }
+        args.options.target = target;
+        break;
+      }
+      case '-c':
+      case '--concurrency':
+        args.options.concurrency = parsePositiveInteger('concurrency', value());
+        break;
+      case '-d':
+      case '--duration':
+        args.options.duration = parsePositiveInteger('duration', value());
+        break;
+      case '-X':
+      case '--method':
+        request.method = value();
+        break;
+      case '-p':
+      case '--path':
+        request.path = value();
+        break;
+      case '-H':
+      case '--header': {
+        const header = value();
+        const index = header.indexOf(':');
+        if (index <= 0) {
+          throw new Error(`Invalid header "${header}", expected "Name: value"`);
+        }
+        request.headers = {
+          ...request.headers,
+          [header.substring(0, index).trim()]: header
+            .substring(index + 1)
+            .trim(),
+        };
+        break;
+      }
+      case '--body':
+        request.body = value();
+        break;
+      case '--json':
+        args.json = true;
+        break;
+      case '-h':
+      case '--help':
+        args.help = true;
+        break;
+      default:
+        if (arg.startsWith('-') || args.options.entrypoint) {
+          throw new Error(`Unexpected argument "${arg}"`);
+        }
+        args.options.entrypoint = arg;
+    }
+  }
+
+  if (!args.options.entrypoint && !args.help) {
+    throw new Error('Missing the entrypoint, e.g. "api/index.go"');
+  }
+  return args;
+}
+
+async function main() {
+  let args: LoadTestArgs;
+  try {
+    args = parseLoadTestArgs(process.argv.slice(2));
+  } catch (err: any) {
+    console.error(`Error: ${err.message}\n\n${usage}`);
+    return 2;
+  }
+  if (args.help) {
+    console.log(usage);
+    return 0;
+  }
+
+  const result = await runLoadTest({
+    ...args.options,
+    workPath: process.cwd(),
+  });
+  if (args.json) {
+    console.log(JSON.stringify(result, null, 2));
+  }
+  return 0;
+}
+
+if (require.main === module) {
+  main().then(
+    code => {
+      process.exitCode = code;
+    },
+    err => {
+      console.error(err);
+      process.exitCode = 1;
+    }
+  );
+}
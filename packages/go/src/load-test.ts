@@ -0,0 +1,176 @@
+import execa from 'execa';
+import { join } from 'path';
+import { pathExists, remove, writeFile } from 'fs-extra';
+import {
+  Lambda,
+  debug,
+  download,
+  getWriteableDirectory,
+  glob,
+} from '@vercel/build-utils';
+import type { Config, Meta } from '@vercel/build-utils';
+import { createGo, OUT_EXTENSION } from './go-helpers';
+import { startDevServer } from './dev-server';
+import { build } from './index';
+
+export interface LoadTestRequest {
+  method?: string;
+  /**
+   * The path of the request, including the query string
+   */
+  path?: string;
+  headers?: Record<string, string>;
+  body?: string;
+}
+
+export interface LoadTestOptions {
+  /**
+   * Whether to drive the dev server of `startDevServer()` (`dev`), or the
+   * production `bootstrap` of `build()` through a stand-in for the Lambda
+   * Runtime API (`bootstrap`)
+   */
+  target: 'dev' | 'bootstrap';
+  entrypoint: string;
+  workPath: string;
+  config?: Config;
+  meta?: Meta;
+  /**
+   * The number of concurrent requests, defaults to 10
+   */
+  concurrency?: number;
+  /**
+   * The duration of the load test in seconds, defaults to 10
+   */
+  duration?: number;
+  /**
+   * The request sent by every worker, defaults to `GET /`
+   */
+  request?: LoadTestRequest;
+}
+
+export interface LoadTestResult {
+  target: string;
+  concurrency: number;
+  durationSeconds: number;
+  requests: number;
+  requestsPerSecond: number;
+  /**
+   * The number of requests that failed or returned a 5xx status
+   */
+  errors: number;
+  errorRate: number;
+  statusCodes: Record<string, number>;
+  latencyMs: { p50: number; p90: number; p99: number; max: number };
+  /**
+   * `-1` when not available, which is the case for the `bootstrap` target
+   */
+  allocsPerRequest: number;
+  bytesPerRequest: number;
+  /**
+   * `-1` when not available, which is the case outside of Linux
+   */
+  peakRssBytes: number;
+}
+
+/**
+ * Builds the `load-test` tool if not found in the `dist` directory.
+ */
+async function getLoadTestBin(workPath: string): Promise<string> {
+  // not `load-test`, which would shadow `load-test.js` next to it
+  const bin = join(__dirname, `go-load-test${OUT_EXTENSION}`);
+  if (!(await pathExists(bin))) {
+    debug(`Building load-test bin: ${bin}`);
+    const go = await createGo({ opts: { cwd: __dirname }, workPath });
+    await go.build(join(__dirname, '../load-test.go'), bin);
+  }
+  return bin;
+}
+
+/**
+ * Runs a load test against a Go function and prints a report with latency
+ * percentiles, the error rate, allocations per request and peak RSS. Run it
+ * with both targets to compare the dev server and the production bootstrap.
+ */
+export async function runLoadTest({
+  target,
+  entrypoint,
+  workPath,
+  config = {},
+  meta = {},
+  concurrency = 10,
+  duration = 10,
+  request = {},
+}: LoadTestOptions): Promise<LoadTestResult> {
+  const bin = await getLoadTestBin(workPath);
+  const tmp = await getWriteableDirectory();
+
+  try {
+    const requestFile = join(tmp, 'request.json');
+    await writeFile(requestFile, JSON.stringify(request));
+    const args = [
+      `-c=${concurrency}`,
+      `-d=${duration}s`,
+      `-request=${requestFile}`,
+      '-json',
+    ];
+
+    if (target === 'dev') {
+      const result = await startDevServer({
+        files: {},
+        entrypoint,
+        workPath,
+        repoRootPath: workPath,
+        config,
+        meta,
+      });
+      if (!result) {
+        throw new Error(`No dev server was started for "${entrypoint}"`);
+      }
+      const url = `http://127.0.0.1:${result.port}`;
+      args.push(
+        `-url=${url}`,
+        `-pid=${result.pid}`,
+        `-memstats=${url}/__vercel_go/memstats`
+      );
+      try {
+        return await runLoadTestBin(bin, args);
+      } finally {
+        if (result.shutdown) {
+          await result.shutdown();
+        }
+      }
+    }
+
+    // the bootstrap is built for the platform
+    if (process.platform !== 'linux' || process.arch !== 'x64') {
+      throw new Error(
+        `The bootstrap target requires linux/x64, but this is ${process.platform}/${process.arch}`
+      );
+    }
+    const { output } = await build({
+      files: await glob('**', { cwd: workPath, ignore: ['.vercel/**'] }),
+      entrypoint,
+      workPath,
+      repoRootPath: workPath,
+      config,
+      meta: { ...meta, skipDownload: true },
+    });
+    const taskDir = join(tmp, 'task');
+    await download((output as Lambda).files || {}, taskDir);
+    args.push(`-bootstrap=${join(taskDir, (output as Lambda).handler)}`);
+    return await runLoadTestBin(bin, args);
+  } finally {
+    await remove(tmp);
+  }
+}
+
+async function runLoadTestBin(
+  bin: string,
+  args: string[]
+): Promise<LoadTestResult> {
+  debug(`Exec: ${bin} ${args.join(' ')}`);
+  const { stdout } = await execa(bin, args, {
+    stdio: ['ignore', 'pipe', 'inherit'],
+  });
+  return JSON.parse(stdout);
+}
@@ -0,0 +1,195 @@
+// A Go version, e.g. `1.21` (a language version), `1.22rc1` or `1.23.2`
+const goVersionRegExp = /^(\d+)\.(\d+)(?:\.(\d+)|(beta|rc)(\d+))?$/;
+
+// The order of language versions, prereleases and releases of the same minor
+// version, e.g. `1.21` < `1.21rc1` < `1.21.0`
+const goVersionKinds = ['', 'beta', 'rc', 'release'];
+
+function parseGoVersion(version: string): number[] {
+  const matches = goVersionRegExp.exec(version.replace(/^go/, ''));
+  if (!matches) {
+    throw new Error(`Invalid Go version "${version}"`);
+  }
+  const [, major, minor, patch, prerelease, n] = matches;
+  const kind = patch ? 'release' : prerelease || '';
+  return [
+    parseInt(major, 10),
+    parseInt(minor, 10),
+    goVersionKinds.indexOf(kind),
+    parseInt(patch || n || '0', 10),
+  ];
+}
+
+/**
+ * Compares two Go versions like Go's `gover.Compare`.
+ *
+ * @returns A negative number if `a` is older than `b`, `0` if they are equal,
+ * and a positive number if `a` is newer than `b`
+ */
+export function compareGoVersions(a: string, b: string): number {
+  const x = parseGoVersion(a);
+  const y = parseGoVersion(b);
+  for (let i = 0; i < x.length; i++) {
+    if (x[i] !== y[i]) {
+      return x[i] - y[i];
+    }
+  }
+  return 0;
+}
+
+/**
+ * Returns the newest of the given Go versions, ignoring `undefined` ones.
+ */
+export function maxGoVersion(
+  ...versions: (string | undefined)[]
+): string | undefined {
+  let max: string | undefined;
+  for (const version of versions) {
+    if (version && (!max || compareGoVersions(version, max) > 0)) {
+      max = version;
+    }
+  }
+  return max;
+}
+
+export interface GoToolchainEnv {
+  /**
+   * The default toolchain, either `local` or a version like `1.22.3`
+   */
+  version: string;
+  /**
+   * Whether to switch to a newer toolchain when the `go.mod` or `go.work`
+   * requires one, downloading it (`auto`) or only from the `PATH` (`path`)
+   */
+  switch?: 'auto' | 'path';
+}
+
+/**
+ * Parses the `GOTOOLCHAIN` environment variable, which is one of `local`,
+ * `auto`, `path`, `go1.x.y`, or `local` or `go1.x.y` with a `+auto` or
+ * `+path` suffix.
+ *
+ * @returns `undefined` if `GOTOOLCHAIN` is not set
+ */
+export function parseGoToolchainEnv(
+  value: string | undefined
+): GoToolchainEnv | undefined {
+  if (!value) {
+    return undefined;
+  }
+  if (value === 'auto' || value === 'path') {
+    return { version: 'local', switch: value };
+  }
+
+  const [name, suffix, ...rest] = value.split('+');
+  const isValidName =
+    name === 'local' ||
+    (name.startsWith('go') && goVersionRegExp.test(name.substring(2)));
+  const isValidSuffix =
+    suffix === undefined || suffix === 'auto' || suffix === 'path';
+  if (!isValidName || !isValidSuffix || rest.length > 0) {
+    throw new Error(
+      `Invalid GOTOOLCHAIN "${value}", expected one of: local, auto, path, go1.x.y, or local or go1.x.y with a "+auto" or "+path" suffix`
+    );
+  }
+  return {
+    version: name === 'local' ? 'local' : name.substring(2),
+    switch: suffix as GoToolchainEnv['switch'],
+  };
+}
+
+export interface GoToolchainSelection {
+  /**
+   * The selected version, or `local` for the Go on the `PATH`
+   */
+  version: string;
+  /**
+   * Whether the selected version may be downloaded if it is not installed
+   */
+  download: boolean;
+  /**
+   * Why the version was selected, for error messages
+   */
+  reason: string;
+}
+
+/**
+ * Selects the Go toolchain like the `go` command does, given the version the
+ * `go.mod` or `go.work` requires and the `GOTOOLCHAIN` environment variable.
+ * Without `GOTOOLCHAIN`, the required version is used, or the newest known
+ * version if none is required.
+ *
+ * @param required The newest of the `go` and `toolchain` lines of the
+ * `go.mod`, or of the `go.work` and the modules it uses (`minimum`), and the
+ * release to install for it (`version`), e.g. `1.22` and `1.22.8`
+ * @param source The file `required` is from
+ * @param gotoolchain The parsed `GOTOOLCHAIN`
+ * @param latest The newest known Go version
+ * @param local The version of the Go on the `PATH`, if any
+ * @throws If the `GOTOOLCHAIN` toolchain is older than the required version
+ * and may not be switched
+ */
+export function selectGoToolchain({
+  required,
+  source = 'go.mod',
+  gotoolchain,
+  latest,
+  local,
+}: {
+  required?: { minimum: string; version: string };
+  source?: string;
+  gotoolchain?: GoToolchainEnv;
+  latest: string;
+  local?: string;
+}): GoToolchainSelection {
+  if (!gotoolchain) {
+    return required
+      ? {
+          version: required.version,
+          download: true,
+          reason: `required by ${source}`,
+        }
+      : { version: latest, download: true, reason: 'the newest known version' };
+  }
+
+  const name =
+    gotoolchain.version === 'local' ? 'local' : `go${gotoolchain.version}`;
+  const env = `GOTOOLCHAIN=${name}${
+    gotoolchain.switch ? `+${gotoolchain.switch}` : ''
+  }`;
+  const defaultVersion =
+    gotoolchain.version === 'local' ? local : gotoolchain.version;
+
+  if (
+    required &&
+    (!defaultVersion || compareGoVersions(defaultVersion, required.minimum) < 0)
+  ) {
+    if (gotoolchain.switch) {
+      return {
+        version: required.version,
+        download: gotoolchain.switch === 'auto',
+        reason: `required by ${source} (${env})`,
+      };
+    }
+    throw new Error(
+      defaultVersion
+        ? `${source} requires go >= ${required.minimum}, but ${env} selects go ${defaultVersion}`
+        : `${source} requires go >= ${required.minimum}, but ${env} is set and go is not on the PATH`
+    );
+  }
+
+  if (gotoolchain.version === 'local') {
+    if (!local && gotoolchain.switch === 'auto') {
+      return { version: latest, download: true, reason: env };
+    }
+    if (!local) {
+      throw new Error(`${env} is set, but go is not on the PATH`);
+    }
+    return { version: 'local', download: false, reason: env };
+  }
+  return {
+    version: gotoolchain.version,
+    download: gotoolchain.switch !== 'path',
+    reason: env,
+  };
+}
@@ -0,0 +1,171 @@
+import { join } from 'path';
+import { tmpdir } from 'os';
+import { chmod, mkdirp, pathExists, remove, utimes, writeFile } from 'fs-extra';
+import {
+  getBuildCacheEntry,
+  getModCacheEntry,
+  getOutputCacheKey,
+  pruneCache,
+} from '../src/cache';
+import type { BuildChecks } from '../src/checks';
+
+describe('getModCacheEntry', function () {
+  it('groups the files of a module version', async () => {
+    const zip = join('cache', 'download', 'example.com', 'a', '@v', 'v1.0.0');
+    expect(getModCacheEntry(`${zip}.zip`)).toEqual(zip);
+    expect(getModCacheEntry(`${zip}.ziphash`)).toEqual(zip);
+    expect(getModCacheEntry(`${zip}.info`)).toEqual(zip);
+    const extracted = join('example.com', 'a@v1.0.0');
+    expect(getModCacheEntry(join(extracted, 'sub', 'a.go'))).toEqual(
+      extracted
+    );
+  });
+});
+
+describe('pruneCache', function () {
+  let dir: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-cache-test-${name}`);
+  });
+
+  afterEach(async () => {
+    await remove(dir);
+  });
+
+  async function writeEntry(file: string, size: number, age: number) {
+    const path = join(dir, file);
+    await mkdirp(join(path, '..'));
+    await writeFile(path, Buffer.alloc(size));
+    const time = Date.now() / 1000 - age;
+    await utimes(path, time, time);
+  }
+
+  it('does not prune a cache under the maximum size', async () => {
+    await writeEntry(join('00', 'a-a'), 10, 100);
+    expect(await pruneCache(dir, 100, getBuildCacheEntry)).toEqual(0);
+    expect(await pathExists(join(dir, '00', 'a-a'))).toEqual(true);
+  });
+
+  it('prunes the least recently used build cache entries', async () => {
+    await writeEntry('README', 10, 300);
+    await writeEntry(join('00', 'old-a'), 40, 200);
+    await writeEntry(join('01', 'new-a'), 40, 0);
+    await writeEntry(join('02', 'mid-a'), 40, 100);
+
+    expect(await pruneCache(dir, 100, getBuildCacheEntry)).toEqual(40);
+    expect(await pathExists(join(dir, 'README'))).toEqual(true);
+    expect(await pathExists(join(dir, '00', 'old-a'))).toEqual(false);
+    expect(await pathExists(join(dir, '01', 'new-a'))).toEqual(true);
+    expect(await pathExists(join(dir, '02', 'mid-a'))).toEqual(true);
+  });
+
+  it('prunes read-only modules as a whole', async () => {
+    const old = join('example.com', 'old@v1.0.0');
+    await writeEntry(join(old, 'a.go'), 30, 200);
+    await writeEntry(join(old, 'sub', 'b.go'), 30, 200);
+    await writeEntry(join('example.com', 'new@v1.0.0', 'a.go'), 30, 0);
+    await chmod(join(dir, old, 'sub'), 0o555);
+    await chmod(join(dir, old), 0o555);
+
+    expect(await pruneCache(dir, 50, getModCacheEntry)).toEqual(60);
+    expect(await pathExists(join(dir, old))).toEqual(false);
+    expect(await pathExists(join(dir, 'example.com', 'new@v1.0.0'))).toEqual(
+      true
+    );
+  });
+});
+
+describe('getOutputCacheKey', function () {
+  let dir: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-output-test-${name}`);
+    await mkdirp(join(dir, 'api'));
+    await writeFile(join(dir, 'go.mod'), 'module example.com/app\n');
+    await writeFile(join(dir, 'api', 'index.go'), 'package api\n');
+  });
+
+  afterEach(async () => {
+    await remove(dir);
+  });
+
+  const getKey = (env = {}, checks?: BuildChecks) =>
+    getOutputCacheKey({
+      workPath: dir,
+      files: [join(dir, 'api', 'index.go')],
+      modulePath: dir,
+      goVersion: '1.23.2',
+      env: { GOOS: 'linux', GOARCH: 'amd64', ...env },
+      includedFiles: {},
+      runtime: 'provided.al2023',
+      checks,
+    });
+
+  it('is stable for unchanged inputs', async () => {
+    expect(await getKey()).toEqual(await getKey());
+  });
+
+  it('changes with the sources, go.sum and build flags', async () => {
+    const key = await getKey();
+    expect(await getKey({ GO_BUILD_FLAGS: '-tags=prod' })).not.toEqual(key);
+
+    await writeFile(join(dir, 'api', 'index.go'), 'package api\n\n');
+    const sourceKey = await getKey();
+    expect(sourceKey).not.toEqual(key);
+
+    await writeFile(join(dir, 'go.sum'), 'example.com/dep v1.0.0 h1:x=\n');
+    expect(await getKey()).not.toEqual(sourceKey);
+  });
+
+  it('changes with the other sources of the package', async () => {
+    const key = await getKey();
+    await writeFile(join(dir, 'api', 'README.md'), '# API\n');
+    expect(await getKey()).toEqual(key);
+
+    for (const name of ['add_amd64.s', 'add.c', 'add.h', 'rsrc.syso']) {
+      const previous = await getKey();
+      await writeFile(join(dir, 'api', name), `// ${name}\n`);
+      expect(await getKey()).not.toEqual(previous);
+    }
+
+    // a Go file that only another platform or build tag compiles
+    const previous = await getKey();
+    await writeFile(join(dir, 'api', 'index_windows.go'), 'package api\n');
+    expect(await getKey()).not.toEqual(previous);
+  });
+
+  it('changes with the tests only when checks are enabled', async () => {
+    const checks = { vet: false, test: true };
+    const key = await getKey();
+    const checksKey = await getKey({}, checks);
+    expect(checksKey).not.toEqual(key);
+
+    await writeFile(join(dir, 'api', 'index_test.go'), 'package api\n');
+    expect(await getKey()).toEqual(key);
+    const testKey = await getKey({}, checks);
+    expect(testKey).not.toEqual(checksKey);
+
+    await mkdirp(join(dir, 'api', 'testdata'));
+    await writeFile(join(dir, 'api', 'testdata', 'users.json'), '[]\n');
+    expect(await getKey({}, checks)).not.toEqual(testKey);
+  });
+
+  it('changes when `go generate` runs before the build', async () => {
+    const getGenerateKey = (generate: boolean) =>
+      getOutputCacheKey({
+        workPath: dir,
+        files: [join(dir, 'api', 'index.go')],
+        modulePath: dir,
+        goVersion: '1.23.2',
+        env: {},
+        includedFiles: {},
+        runtime: 'provided.al2023',
+        generate,
+      });
+
+    expect(await getGenerateKey(true)).not.toEqual(await getGenerateKey(false));
+  });
+});
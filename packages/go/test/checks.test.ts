@@ -0,0 +1,165 @@
+import { join } from 'path';
+import { tmpdir } from 'os';
+import { mkdirp, remove, writeFile } from 'fs-extra';
+import {
+  countVetIssues,
+  getBuildChecks,
+  getBuildTagFlags,
+  runBuildChecks,
+  summarizeTestEvents,
+} from '../src/checks';
+import { GoWrapper } from '../src/go-helpers';
+
+const events = (...lines: object[]) =>
+  lines.map(line => JSON.stringify(line)).join('\n');
+
+describe('getBuildChecks', function () {
+  it('disables the checks by default', async () => {
+    expect(getBuildChecks()).toEqual({ vet: false, test: false });
+  });
+
+  it('reads the goVet and goTest config', async () => {
+    expect(getBuildChecks({ goVet: true, goTest: false })).toEqual({
+      vet: true,
+      test: false,
+    });
+  });
+
+  it('throws on a value that is not a boolean', async () => {
+    expect(() => getBuildChecks({ goTest: 'yes' })).toThrow(
+      'Invalid `goTest` config "yes", expected a boolean'
+    );
+  });
+});
+
+describe('getBuildTagFlags', function () {
+  it('returns the build tags of GO_BUILD_FLAGS', async () => {
+    const env = {
+      GO_BUILD_FLAGS: '-ldflags "-s -w" -tags prod,json -trimpath',
+    };
+    expect(getBuildTagFlags(env)).toEqual(['-tags', 'prod,json']);
+    expect(getBuildTagFlags({ GO_BUILD_FLAGS: '--tags=prod' })).toEqual([
+      '--tags=prod',
+    ]);
+    expect(getBuildTagFlags({})).toEqual([]);
+  });
+});
+
+describe('summarizeTestEvents', function () {
+  it('counts the tests and keeps the output of failed tests', async () => {
+    const Package = 'example.com/app/api';
+    const summary = summarizeTestEvents(
+      events(
+        { Action: 'run', Package, Test: 'TestPass' },
+        { Action: 'output', Package, Test: 'TestPass', Output: 'ok\n' },
+        { Action: 'pass', Package, Test: 'TestPass' },
+        { Action: 'run', Package, Test: 'TestSkip' },
+        { Action: 'skip', Package, Test: 'TestSkip' },
+        { Action: 'run', Package, Test: 'TestFail' },
+        {
+          Action: 'output',
+          Package,
+          Test: 'TestFail',
+          Output: '    api_test.go:7: want 2, got 1\n',
+        },
+        { Action: 'fail', Package, Test: 'TestFail' },
+        { Action: 'output', Package, Output: `FAIL\t${Package}\t0.002s\n` },
+        { Action: 'fail', Package },
+        { Action: 'output', Package: 'example.com/app', Output: 'ok\n' },
+        { Action: 'pass', Package: 'example.com/app' }
+      )
+    );
+    expect(summary).toEqual({
+      passed: 1,
+      failed: 1,
+      skipped: 1,
+      failedPackages: [Package],
+      output: `    api_test.go:7: want 2, got 1\nFAIL\t${Package}\t0.002s\n`,
+    });
+  });
+
+  it('keeps the output of failed builds', async () => {
+    const ImportPath = 'example.com/app [example.com/app.test]';
+    const summary = summarizeTestEvents(
+      events(
+        { ImportPath, Action: 'build-output', Output: '# example.com/app\n' },
+        { ImportPath, Action: 'build-output', Output: 'x_test.go:5:2: bad\n' },
+        { ImportPath, Action: 'build-fail' },
+        { Action: 'fail', Package: 'example.com/app' }
+      ) + '\nnot json'
+    );
+    expect(summary.failedPackages).toEqual(['example.com/app']);
+    expect(summary.output).toEqual(
+      '# example.com/app\nx_test.go:5:2: bad\nnot json\n'
+    );
+  });
+});
+
+describe('countVetIssues', function () {
+  it('counts the issues of go vet', async () => {
+    const output = [
+      '# example.com/app',
+      'api/index.go:5:24: fmt.Printf format %d has arg "x" of wrong type string',
+      'vet: api/index_test.go:5:28: undefined: undefined',
+    ].join('\n');
+    expect(countVetIssues(output)).toEqual(2);
+  });
+});
+
+describe('runBuildChecks', function () {
+  let dir: string;
+  let go: GoWrapper;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-checks-test-${name}`);
+    await mkdirp(join(dir, 'api'));
+    await writeFile(join(dir, 'go.mod'), 'module example.com/app\n\ngo 1.13\n');
+    await writeFile(
+      join(dir, 'util.go'),
+      'package app\n\nfunc Double(n int) int { return n * 2 }\n'
+    );
+    await writeFile(
+      join(dir, 'api', 'index.go'),
+      'package api\n\nimport "example.com/app"\n\nvar Four = app.Double(2)\n'
+    );
+    go = new GoWrapper(
+      { ...process.env, GOFLAGS: '', GOWORK: 'off' },
+      { cwd: join(dir, 'api') }
+    );
+  });
+
+  afterEach(async () => {
+    await remove(dir);
+  });
+
+  it('runs go vet and the tests of the local packages', async () => {
+    await writeFile(
+      join(dir, 'util_test.go'),
+      'package app\n\nimport "testing"\n\nfunc TestDouble(t *testing.T) {\n\tif Double(2) != 4 {\n\t\tt.Fail()\n\t}\n}\n'
+    );
+    await runBuildChecks(go, { vet: true, test: true }, {});
+  });
+
+  it('fails on a failed test of a dependency', async () => {
+    await writeFile(
+      join(dir, 'util_test.go'),
+      'package app\n\nimport "testing"\n\nfunc TestDouble(t *testing.T) {\n\tif Double(2) != 5 {\n\t\tt.Fail()\n\t}\n}\n'
+    );
+    await expect(
+      runBuildChecks(go, { vet: false, test: true }, {})
+    ).rejects.toThrow(
+      '`go test` failed in example.com/app (0 passed, 1 failed, 0 skipped)'
+    );
+  });
+
+  it('fails on issues of go vet', async () => {
+    await writeFile(
+      join(dir, 'api', 'log.go'),
+      'package api\n\nimport "fmt"\n\nfunc Log() { fmt.Printf("%d", "x") }\n'
+    );
+    await expect(
+      runBuildChecks(go, { vet: true, test: false }, {})
+    ).rejects.toThrow('`go vet` reported 1 issue');
+  });
+});
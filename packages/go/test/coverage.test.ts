@@ -0,0 +1,68 @@
+import {
+  formatCoverageSummary,
+  mapCoverageProfile,
+  summarizeCoverageProfile,
+} from '../src/coverage';
+
+const profile = `mode: set
+handler/api/entrypoint.go:10.2,12.16 2 1
+handler/api/entrypoint.go:14.2,15.3 1 0
+handler/api/vercel-dev-server-main.go:42.2,45.1 3 0
+example.com/app/lib/util.go:5.40,7.2 1 1
+`;
+
+describe('mapCoverageProfile', function () {
+  it('rewrites and drops files', async () => {
+    const mapped = mapCoverageProfile(profile, file => {
+      if (file === 'handler/api/entrypoint.go') {
+        return 'api/index.go';
+      }
+      if (file.startsWith('example.com/app/')) {
+        return file.substring('example.com/app/'.length);
+      }
+      return undefined;
+    });
+    expect(mapped).toEqual(`mode: set
+api/index.go:10.2,12.16 2 1
+api/index.go:14.2,15.3 1 0
+lib/util.go:5.40,7.2 1 1
+`);
+  });
+});
+
+describe('summarizeCoverageProfile', function () {
+  it('computes the covered statements of each file', async () => {
+    const summaries = summarizeCoverageProfile(profile);
+    expect(summaries).toEqual([
+      { file: 'example.com/app/lib/util.go', statements: 1, covered: 1 },
+      { file: 'handler/api/entrypoint.go', statements: 3, covered: 2 },
+      {
+        file: 'handler/api/vercel-dev-server-main.go',
+        statements: 3,
+        covered: 0,
+      },
+    ]);
+  });
+
+  it('counts blocks merged from several runs once', async () => {
+    const summaries = summarizeCoverageProfile(`mode: set
+api/index.go:10.2,12.16 2 0
+api/index.go:10.2,12.16 2 1
+`);
+    expect(summaries).toEqual([
+      { file: 'api/index.go', statements: 2, covered: 2 },
+    ]);
+  });
+});
+
+describe('formatCoverageSummary', function () {
+  it('includes the total', async () => {
+    const summary = formatCoverageSummary([
+      { file: 'api/index.go', statements: 4, covered: 3 },
+      { file: 'lib/util.go', statements: 4, covered: 1 },
+    ]);
+    expect(summary).toEqual(`api/index.go   75.0%
+lib/util.go    25.0%
+total          50.0%`);
+  });
+});
@@ -0,0 +1,126 @@
+import { join } from 'path';
+import { getDevOptions } from '../src/dev-options';
+
+describe('getDevOptions', function () {
+  it('returns no options with empty env', async () => {
+    const options = getDevOptions({});
+    expect(options).toEqual({});
+  });
+
+  it('ignores non-affirmative values', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_DEBUG: '0' });
+    expect(options.debug).toBeUndefined();
+  });
+
+  it('returns debug options with default port', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_DEBUG: '1' });
+    expect(options.debug).toEqual({ address: '127.0.0.1:2345', wait: false });
+  });
+
+  it('returns debug options with custom port and wait', async () => {
+    const options = getDevOptions({
+      VERCEL_DEV_GO_DEBUG: 'true',
+      VERCEL_DEV_GO_DEBUG_PORT: '40000',
+      VERCEL_DEV_GO_DEBUG_WAIT: '1',
+    });
+    expect(options.debug).toEqual({ address: '127.0.0.1:40000', wait: true });
+  });
+
+  it('returns race options', async () => {
+    const options = getDevOptions({
+      VERCEL_DEV_GO_RACE: '1',
+      VERCEL_DEV_GO_RACE_HEADER: 'true',
+    });
+    expect(options.race).toEqual({ header: true });
+  });
+
+  it('returns strict options with default maxDuration', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_STRICT: '1' });
+    expect(options.strict).toEqual({ maxDuration: 10 });
+  });
+
+  it('returns strict options with maxDuration from config', async () => {
+    const options = getDevOptions(
+      { VERCEL_DEV_GO_STRICT: '1' },
+      { maxDuration: 60 }
+    );
+    expect(options.strict).toEqual({ maxDuration: 60 });
+  });
+
+  it('returns platform headers options', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_PLATFORM_HEADERS: '1' });
+    expect(options.platformHeaders).toEqual({ file: undefined });
+  });
+
+  it('enables platform headers with a fixture file', async () => {
+    const options = getDevOptions({
+      VERCEL_DEV_GO_PLATFORM_HEADERS_FILE: 'headers.json',
+    });
+    expect(options.platformHeaders).toEqual({ file: 'headers.json' });
+  });
+
+  it('returns record options', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_RECORD: '1' });
+    expect(options.record).toEqual({ file: undefined });
+  });
+
+  it('returns record options with a HAR file', async () => {
+    const options = getDevOptions({
+      VERCEL_DEV_GO_RECORD_FILE: 'recordings/api.har',
+    });
+    expect(options.record).toEqual({ file: 'recordings/api.har' });
+  });
+
+  it('returns cassette options with defaults', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_CASSETTES: '1' });
+    expect(options.cassettes).toEqual({
+      dir: join('.vercel', 'go-cassettes'),
+      mode: 'auto',
+    });
+  });
+
+  it('returns cassette options with a custom dir and mode', async () => {
+    const options = getDevOptions({
+      VERCEL_DEV_GO_CASSETTE_DIR: 'fixtures/http',
+      VERCEL_DEV_GO_CASSETTE_MODE: 'replay',
+    });
+    expect(options.cassettes).toEqual({ dir: 'fixtures/http', mode: 'replay' });
+  });
+
+  it('throws on an invalid cassette mode', async () => {
+    expect(() =>
+      getDevOptions({
+        VERCEL_DEV_GO_CASSETTES: '1',
+        VERCEL_DEV_GO_CASSETTE_MODE: 'sometimes',
+      })
+    ).toThrow('Invalid VERCEL_DEV_GO_CASSETTE_MODE "sometimes"');
+  });
+
+  it('returns the freeze option', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_FREEZE: 'true' });
+    expect(options.freeze).toBe(true);
+  });
+
+  it('returns the cold start option', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_COLD_START: '1' });
+    expect(options.coldStart).toBe(true);
+  });
+
+  it('returns leak options with default grace period', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_LEAKS: '1' });
+    expect(options.leaks).toEqual({ grace: 1000 });
+  });
+
+  it('returns leak options with custom grace period', async () => {
+    const options = getDevOptions({
+      VERCEL_DEV_GO_LEAKS: '1',
+      VERCEL_DEV_GO_LEAK_GRACE: '250',
+    });
+    expect(options.leaks).toEqual({ grace: 250 });
+  });
+
+  it('returns the pgo option', async () => {
+    const options = getDevOptions({ VERCEL_DEV_GO_PGO: '1' });
+    expect(options.pgo).toBe(true);
+  });
+});
@@ -0,0 +1,234 @@
+import execa from 'execa';
+import fetch from 'node-fetch';
+import { dirname, join } from 'path';
+import { tmpdir } from 'os';
+import {
+  copy,
+  mkdirp,
+  pathExists,
+  readFile,
+  remove,
+  writeFile,
+} from 'fs-extra';
+import type { Config } from '@vercel/build-utils';
+import { startDevServer, stopDevServers } from '../src/dev-server';
+
+jest.setTimeout(2 * 60 * 1000);
+
+describe('dev-server.go', function () {
+  let dir: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-dev-server-test-${name}`);
+    await mkdirp(dir);
+
+    // the dev server as `startDevServer()` renders it, next to its tests
+    const devServer = await readFile(
+      join(__dirname, '../dev-server.go'),
+      'utf8'
+    );
+    await writeFile(
+      join(dir, 'vercel-dev-server-main.go'),
+      devServer.replace('__HANDLER_FUNC_NAME', 'Handler')
+    );
+    await writeFile(
+      join(dir, 'entrypoint.go'),
+      'package main\n\nimport (\n\t"net/http"\n\t"time"\n)\n\nfunc Handler(w http.ResponseWriter, r *http.Request) {\n\tsleep, _ := time.ParseDuration(r.URL.Query().Get("sleep"))\n\ttime.Sleep(sleep)\n\tw.Write([]byte("ok"))\n}\n'
+    );
+    await writeFile(
+      join(dir, 'go.mod'),
+      'module vercel-dev-server-test\n\ngo 1.20\n'
+    );
+    await copy(
+      join(__dirname, 'dev-server_test.go'),
+      join(dir, 'vercel-dev-server-main_test.go')
+    );
+  });
+
+  afterEach(async () => {
+    await remove(dir);
+  });
+
+  it('passes its Go tests', async () => {
+    await execa('go', ['test', '-count=1', '.'], {
+      cwd: dir,
+      env: { GOFLAGS: '', GOWORK: 'off' },
+    });
+  });
+});
+
+describe('startDevServer in record mode', function () {
+  let workPath: string;
+
+  const handler = (greeting: string) =>
+    `package api\n\nimport (\n\t"io/ioutil"\n\t"net/http"\n)\n\nfunc Handler(w http.ResponseWriter, r *http.Request) {\n\tbody, _ := ioutil.ReadAll(r.Body)\n\tw.Header().Set("Content-Type", "text/plain")\n\tw.Header().Set("X-Name", r.URL.Query().Get("name"))\n\tw.Write([]byte("${greeting} " + string(body)))\n}\n`;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    workPath = join(tmpdir(), `vc-go-dev-record-test-${name}`);
+    await mkdirp(join(workPath, 'api'));
+    await writeFile(
+      join(workPath, 'go.mod'),
+      'module example.com/app\n\ngo 1.20\n'
+    );
+    await writeFile(join(workPath, 'api', 'index.go'), handler('hello'));
+  });
+
+  afterEach(async () => {
+    await remove(workPath);
+  });
+
+  it('records a request that the generated test replays', async () => {
+    const result = await startDevServer({
+      files: {},
+      entrypoint: 'api/index.go',
+      workPath,
+      config: {},
+      meta: { env: { VERCEL_DEV_GO_RECORD: '1' } },
+    });
+    if (!result) {
+      throw new Error('No dev server was started');
+    }
+    try {
+      const res = await fetch(
+        `http://127.0.0.1:${result.port}/api?name=replay`,
+        { method: 'POST', body: 'world' }
+      );
+      expect(await res.text()).toEqual('hello world');
+    } finally {
+      if (result.shutdown) {
+        await result.shutdown();
+      }
+    }
+
+    const har = JSON.parse(
+      await readFile(join(workPath, 'api', 'testdata', 'index.har'), 'utf8')
+    );
+    expect(har.log.entries.length).toEqual(1);
+    const [{ request, response }] = har.log.entries;
+    expect(request.method).toEqual('POST');
+    expect(request.url).toMatch(/^http:\/\/127\.0\.0\.1:\d+\/api\?name=/);
+    expect(request.postData.text).toEqual('world');
+    expect(response.status).toEqual(200);
+    expect(response.content.text).toEqual('hello world');
+
+    // the replay test is generated next to the entrypoint
+    const test = ['test', '-count=1', './api'];
+    const env = { GOFLAGS: '', GOWORK: 'off' };
+    await execa('go', test, { cwd: workPath, env });
+
+    // and fails once the handler responds differently
+    await writeFile(join(workPath, 'api', 'index.go'), handler('goodbye'));
+    await expect(execa('go', test, { cwd: workPath, env })).rejects.toThrow(
+      'recorded "hello world"'
+    );
+  });
+});
+
+describe('startDevServer in `vercel dev`', function () {
+  let workPath: string;
+
+  const handler = (greeting: string) =>
+    `package api\n\nimport "net/http"\n\nfunc Handler(w http.ResponseWriter, r *http.Request) {\n\tw.Write([]byte("${greeting}"))\n}\n`;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    workPath = join(tmpdir(), `vc-go-dev-server-start-test-${name}`);
+    await mkdirp(join(workPath, 'api'));
+    await writeFile(
+      join(workPath, 'go.mod'),
+      'module example.com/app\n\ngo 1.20\n'
+    );
+    await writeFile(join(workPath, 'api', 'index.go'), handler('hello'));
+  });
+
+  afterEach(async () => {
+    await stopDevServers();
+    await remove(workPath);
+  });
+
+  const request = async (
+    env: { [name: string]: string } = {},
+    config: Config = {}
+  ) => {
+    const result = await startDevServer({
+      files: {},
+      entrypoint: 'api/index.go',
+      workPath,
+      config,
+      meta: { isDev: true, env },
+    });
+    if (!result) {
+      throw new Error('No dev server was started');
+    }
+    try {
+      const res = await fetch(`http://127.0.0.1:${result.port}/api`);
+      return { pid: result.pid, text: await res.text() };
+    } finally {
+      if (result.shutdown) {
+        await result.shutdown();
+      }
+    }
+  };
+
+  it('starts a dev server for every request', async () => {
+    const first = await request();
+    const second = await request();
+    expect(first.text).toEqual('hello');
+    expect(second.pid).not.toEqual(first.pid);
+  });
+
+  it('runs in a directory with the included files, like a Lambda', async () => {
+    await writeFile(
+      join(workPath, 'api', 'index.go'),
+      'package api\n\nimport (\n\t"io/ioutil"\n\t"net/http"\n)\n\nfunc Handler(w http.ResponseWriter, r *http.Request) {\n\tbts, err := ioutil.ReadFile("templates/foo.txt")\n\tif err != nil {\n\t\thttp.Error(w, err.Error(), http.StatusInternalServerError)\n\t\treturn\n\t}\n\tw.Write(bts)\n}\n'
+    );
+    await mkdirp(join(workPath, 'api', 'templates'));
+    await writeFile(
+      join(workPath, 'api', 'templates', 'foo.txt'),
+      'foobar from file'
+    );
+
+    // relative to the entrypoint, like the `includeFiles` of `build()`
+    const { text } = await request({}, { includeFiles: ['templates/**'] });
+    expect(text).toEqual('foobar from file');
+  });
+
+  it('keeps frozen dev servers running until the sources change', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    const env = { VERCEL_DEV_GO_FREEZE: '1' };
+    // concurrent requests are handled by dev servers of their own
+    const [first, second] = await Promise.all([request(env), request(env)]);
+    expect(first.text).toEqual('hello');
+    expect(second.pid).not.toEqual(first.pid);
+    const pids = [first.pid, second.pid];
+    expect(pids).toContain((await request(env)).pid);
+
+    // a file that the handler may read at runtime
+    await writeFile(join(workPath, 'api', 'data.json'), '{}');
+    expect(pids).not.toContain((await request(env)).pid);
+
+    await writeFile(join(workPath, 'api', 'index.go'), handler('goodbye'));
+    expect((await request(env)).text).toEqual('goodbye');
+  });
+
+  it('merges the coverage data of a run without earlier data', async () => {
+    const coverDir = join(workPath, '.vercel', 'go-coverage');
+    const stale = join(coverDir, 'data', 'api_index', 'covcounters.stale');
+    await mkdirp(dirname(stale));
+    await writeFile(stale, '');
+
+    const env = { VERCEL_DEV_GO_COVER: '1' };
+    await request(env);
+    await request(env);
+
+    expect(await pathExists(stale)).toEqual(false);
+    const profile = await readFile(join(coverDir, 'api_index.out'), 'utf8');
+    expect(profile).toMatch(/^mode: /);
+    expect(profile).toContain('api/index.go:');
+  });
+});
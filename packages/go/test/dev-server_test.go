@@ -0,0 +1,604 @@
+package main
+
+// Tests of `dev-server.go`, which `dev-server.test.ts` runs in a module with
+// the rendered dev server and a stub entrypoint.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// In cold start mode, the dev server starts its own executable again for every
+// request, which is the test binary here.
+func TestMain(m *testing.M) {
+	if vcIsEnabled("VERCEL_DEV_GO_COLD_START_CHILD") {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// captureStderr collects what is written to `os.Stderr` until the returned
+// function is called.
+func captureStderr(t *testing.T) func() string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = writer
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		out <- string(data)
+	}()
+	return func() string {
+		os.Stderr = stderr
+		writer.Close()
+		return <-out
+	}
+}
+
+func TestPlatformHeadersKeepTheValuesOfVercelDev(t *testing.T) {
+	var got http.Header
+	handler := vcWithPlatformHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}), map[string]string{"X-Vercel-Ip-Country": "DE", "X-Vercel-Ip-City": ""})
+
+	// the headers `vercel dev` sets when it proxies the request
+	req := httptest.NewRequest("GET", "http://localhost:3000/api", nil)
+	req.RemoteAddr = "127.0.0.1:54321"
+	req.Header.Set("X-Vercel-Id", "dev1::abcde-1700000000000-0123456789ab")
+	req.Header.Set("X-Forwarded-For", "192.168.1.20")
+	req.Header.Set("X-Real-Ip", "192.168.1.20")
+	req.Header.Set("X-Vercel-Forwarded-For", "192.168.1.20")
+	req.Header.Set("X-Vercel-Ip-City", "Berlin")
+	req.Header.Set("Accept", "text/plain")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := map[string][]string{
+		"X-Vercel-Id":             {"dev1::abcde-1700000000000-0123456789ab"},
+		"X-Forwarded-For":         {"192.168.1.20"},
+		"X-Real-Ip":               {"192.168.1.20"},
+		"X-Vercel-Forwarded-For":  {"192.168.1.20"},
+		"X-Forwarded-Proto":       {"http"},
+		"X-Forwarded-Host":        {"localhost:3000"},
+		"X-Vercel-Deployment-Url": {"localhost:3000"},
+		"X-Vercel-Ip-Country":     {"DE"},
+		"X-Vercel-Ip-Timezone":    {"America/Los_Angeles"},
+		"Accept":                  {"text/plain"},
+	}
+	for name, values := range expected {
+		if !reflect.DeepEqual(got[name], values) {
+			t.Errorf("%s: got %q, expected %q", name, got[name], values)
+		}
+	}
+	if values, ok := got["X-Vercel-Ip-City"]; ok {
+		t.Errorf("X-Vercel-Ip-City: got %q, expected the header file to remove it", values)
+	}
+}
+
+func TestPlatformHeadersFillInMissingHeaders(t *testing.T) {
+	var got http.Header
+	handler := vcWithPlatformHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}), nil)
+
+	req := httptest.NewRequest("GET", "https://localhost:3000/api", nil)
+	req.RemoteAddr = "127.0.0.1:54321"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if id := got.Get("X-Vercel-Id"); !strings.HasPrefix(id, "dev1::") {
+		t.Errorf("X-Vercel-Id: got %q, expected a generated ID", id)
+	}
+	for name, value := range map[string]string{
+		"X-Forwarded-For":   "127.0.0.1",
+		"X-Real-Ip":         "127.0.0.1",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "localhost:3000",
+	} {
+		if got.Get(name) != value {
+			t.Errorf("%s: got %q, expected %q", name, got.Get(name), value)
+		}
+	}
+}
+
+// withCassettes serves the requests of `http.DefaultClient` from cassettes in
+// a new directory, and counts the requests that reach the upstream server.
+func withCassettes(t *testing.T, mode string) (upstream *httptest.Server, dir string, hits *int32) {
+	hits = new(int32)
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "upstream-session"})
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+	}))
+	dir = t.TempDir()
+
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = &http.Transport{}
+	vcInstallCassettes(dir, mode)
+	t.Cleanup(func() {
+		http.DefaultTransport = defaultTransport
+		upstream.Close()
+	})
+	return upstream, dir, hits
+}
+
+func send(t *testing.T, method, url, body string, header http.Header) (string, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	// a new client, since `http.DefaultClient` reads the transport on use
+	res, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	return string(data), err
+}
+
+func TestCassettesKeepTheDefaultTransport(t *testing.T) {
+	withCassettes(t, "auto")
+	if _, ok := http.DefaultTransport.(*http.Transport); !ok {
+		t.Fatalf("http.DefaultTransport is a %T", http.DefaultTransport)
+	}
+}
+
+func TestCassettesMatchMethodURLAndBody(t *testing.T) {
+	upstream, _, hits := withCassettes(t, "auto")
+
+	requests := []struct {
+		method, path, body string
+		hits               int32
+	}{
+		{"GET", "/a", "", 1},
+		{"GET", "/a", "", 1},
+		{"POST", "/a", "x", 2},
+		{"POST", "/a", "y", 3},
+		{"POST", "/a", "x", 3},
+		{"PUT", "/a", "x", 4},
+		{"GET", "/a?page=2", "", 5},
+		{"GET", "/b", "", 6},
+		{"GET", "/a?page=2", "", 6},
+	}
+	for _, r := range requests {
+		got, err := send(t, r.method, upstream.URL+r.path, r.body, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := r.method + " " + r.path + " " + r.body; got != expected {
+			t.Errorf("%s %s %q: got %q, expected %q", r.method, r.path, r.body, got, expected)
+		}
+		if n := atomic.LoadInt32(hits); n != r.hits {
+			t.Errorf("%s %s %q: %d requests reached the server, expected %d", r.method, r.path, r.body, n, r.hits)
+		}
+	}
+}
+
+func TestCassettesReplayMissIsAnError(t *testing.T) {
+	upstream, _, hits := withCassettes(t, "replay")
+
+	_, err := send(t, "GET", upstream.URL+"/a", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "no cassette for GET "+upstream.URL+"/a") {
+		t.Errorf("got error %v, expected a missing cassette", err)
+	}
+	if n := atomic.LoadInt32(hits); n != 0 {
+		t.Errorf("%d requests reached the server in replay mode", n)
+	}
+}
+
+func TestCassettesRecordModeRecordsAgain(t *testing.T) {
+	upstream, _, hits := withCassettes(t, "record")
+
+	for i := 0; i < 2; i++ {
+		if _, err := send(t, "GET", upstream.URL+"/a", "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 2 {
+		t.Errorf("%d requests reached the server in record mode, expected 2", n)
+	}
+}
+
+func TestCassettesMaskCredentials(t *testing.T) {
+	upstream, dir, hits := withCassettes(t, "auto")
+	header := http.Header{
+		"Authorization": {"Bearer secret-token"},
+		"Cookie":        {"session=secret-session"},
+		"X-Api-Key":     {"secret-key"},
+	}
+	url := strings.Replace(upstream.URL, "http://", "http://user:secret-password@", 1) + "/a?api_key=secret-query&page=1"
+
+	for i := 0; i < 2; i++ {
+		if _, err := send(t, "GET", url, "", header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("%d requests reached the server, expected the second one from the cassette", n)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("got cassettes %q, expected one", files)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cassette vcCassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		t.Fatal(err)
+	}
+	// the response body echoes the request, so only the rest is checked
+	recorded := fmt.Sprint(cassette.Request.URL, cassette.Request.Headers, cassette.Response.Headers)
+	for _, secret := range []string{"secret-token", "secret-session", "secret-key", "secret-password", "secret-query", "upstream-session"} {
+		if strings.Contains(recorded, secret) {
+			t.Errorf("the cassette contains %q:\n%s", secret, data)
+		}
+	}
+	if !strings.Contains(cassette.Request.URL, "page=1") {
+		t.Errorf("the cassette does not contain the query:\n%s", data)
+	}
+}
+
+// A report of the race detector, with the location of the generated entrypoint
+const raceReport = `WARNING: DATA RACE
+Write at 0x00c0000a4018 by goroutine 9:
+  vercel-dev-server-test.Handler()
+      %[1]s:12 +0x44
+
+Previous write at 0x00c0000a4018 by goroutine 8:
+  vercel-dev-server-test.Handler()
+      %[1]s:12 +0x44
+`
+
+func TestRaceReportsAreSurfacedPerRequest(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GORACE", "exitcode=0 log_path="+filepath.Join(dir, "race"))
+	_, self, _, _ := runtime.Caller(0)
+	report := fmt.Sprintf(raceReport, path.Join(path.Dir(self), "entrypoint.go"))
+
+	sourcePaths := vcSourcePaths
+	vcSourcePaths = vcNewSourcePaths("api/index.go")
+	defer func() { vcSourcePaths = sourcePaths }()
+
+	log := vcNewRaceLog(1234)
+	if log == nil || log.path != filepath.Join(dir, "race.1234") {
+		t.Fatalf("got the race log %+v, expected the log_path of GORACE with the pid", log)
+	}
+	handler := vcWithRaceReports(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/race" {
+			// a complete report, and one the race detector is still writing
+			file, _ := os.OpenFile(log.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			defer file.Close()
+			fmt.Fprintf(file, "%s%s%s%s", vcRaceSeparator, report, vcRaceSeparator, vcRaceSeparator+"WARNING: DATA RACE\n")
+		}
+		w.Write([]byte("ok"))
+	}), log, true)
+
+	for _, test := range []struct{ path, header, stderr string }{
+		{"/", "", ""},
+		{"/race", "1 data race(s) at api/index.go:12", "1 DATA RACE(S) DETECTED while handling GET /race"},
+		{"/", "", ""},
+	} {
+		stderr := captureStderr(t)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+		out := stderr()
+
+		if header := recorder.Header().Get("X-Vercel-Go-Race"); header != test.header {
+			t.Errorf("%s: X-Vercel-Go-Race: got %q, expected %q", test.path, header, test.header)
+		}
+		if recorder.Body.String() != "ok" {
+			t.Errorf("%s: got %q, expected the response of the handler", test.path, recorder.Body.String())
+		}
+		if test.stderr == "" {
+			if out != "" {
+				t.Errorf("%s: got a report for a request without races:\n%s", test.path, out)
+			}
+			continue
+		}
+		if !strings.Contains(out, test.stderr) || !strings.Contains(out, "      api/index.go:12 +0x44") {
+			t.Errorf("%s: got %q, expected %q and the path of the entrypoint", test.path, out, test.stderr)
+		}
+		if strings.Contains(out, "entrypoint.go") {
+			t.Errorf("%s: the report contains the path of the generated entrypoint:\n%s", test.path, out)
+		}
+	}
+}
+
+func TestColdStartHandlesEveryRequestInANewProcess(t *testing.T) {
+	stderr := captureStderr(t)
+	supervisor := vcNewColdStartSupervisor()
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		supervisor.ServeHTTP(rec, httptest.NewRequest("GET", "/api", nil))
+		if body := rec.Body.String(); body != "ok" {
+			t.Errorf("got %d %q, expected the response of the handler", rec.Code, body)
+		}
+		if timing := rec.Header().Get("Server-Timing"); !strings.HasPrefix(timing, "cold-start;dur=") {
+			t.Errorf("Server-Timing: got %q, expected the cold start duration", timing)
+		}
+	}
+	supervisor.children.Wait()
+
+	if n := strings.Count(stderr(), "Cold start for GET /api took "); n != 2 {
+		t.Errorf("got %d cold start reports, expected 2", n)
+	}
+}
+
+// startDevServer starts the dev server in a new process of the test binary,
+// and sends it a request that the handler completes after `sleep`.
+func startDevServer(t *testing.T, sleep string) (stop func() int, response <-chan string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shutdown signals are not supported on Windows")
+	}
+	cmd, port, err := vcStartColdStartChild()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string, 1)
+	go func() {
+		body, err := send(t, "GET", fmt.Sprintf("http://127.0.0.1:%d/?sleep=%s", port, sleep), "", nil)
+		if err != nil {
+			body = err.Error()
+		}
+		out <- body
+	}()
+
+	// give the request time to reach the handler
+	time.Sleep(200 * time.Millisecond)
+	return func() int {
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+		return cmd.ProcessState.ExitCode()
+	}, out
+}
+
+func TestShutdownCompletesTheRequestsInFlight(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("VERCEL_DEV_GO_PGO_DIR", dir)
+	// the dev server inherits the stderr of the test when it starts
+	stderr := captureStderr(t)
+	stop, response := startDevServer(t, "500ms")
+	code := stop()
+	out := stderr()
+	if code != vcExitShutdown {
+		t.Errorf("got the exit code %d, expected %d:\n%s", code, vcExitShutdown, out)
+	}
+	if body := <-response; body != "ok" {
+		t.Errorf("got %q, expected the response of the handler", body)
+	}
+
+	// the CPU profile is only written by its shutdown hook
+	profiles, _ := filepath.Glob(filepath.Join(dir, "cpu-*.pprof"))
+	if len(profiles) != 1 {
+		t.Fatalf("got the profiles %v, expected one", profiles)
+	}
+	if info, err := os.Stat(profiles[0]); err != nil || info.Size() == 0 {
+		t.Errorf("the shutdown hooks did not run, the CPU profile is empty")
+	}
+}
+
+func TestShutdownExitsWithDrainTimeoutWhenAHandlerOutlivesIt(t *testing.T) {
+	t.Setenv("VERCEL_DEV_GO_DRAIN_TIMEOUT", "1")
+	// the dev server inherits the stderr of the test when it starts
+	stderr := captureStderr(t)
+	stop, response := startDevServer(t, "1m")
+	code := stop()
+	out := stderr()
+	if code != vcExitDrainTimeout {
+		t.Errorf("got the exit code %d, expected %d:\n%s", code, vcExitDrainTimeout, out)
+	}
+	if expected := "In-flight requests did not complete within 1s"; !strings.Contains(out, expected) {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+	if body := <-response; body == "ok" {
+		t.Errorf("got the response of a handler that outlived the drain timeout")
+	}
+}
+
+func leakyWorker(stop chan struct{}) {
+	<-stop
+}
+
+func TestLeakDetectionReportsGoroutinesAliveAfterTheResponse(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	handler := vcWithLeakDetection(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/leak" {
+			go leakyWorker(stop)
+		}
+	}), 10*time.Millisecond)
+
+	// served like by the dev server, as the goroutine of the test runs the
+	// code of the test package
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, path := range []string{"/ok", "/leak"} {
+		stderr := captureStderr(t)
+		if _, err := send(t, "GET", server.URL+path, "", nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		out := stderr()
+
+		if path == "/ok" {
+			if out != "" {
+				t.Errorf("%s: got a report for a request without goroutines:\n%s", path, out)
+			}
+			continue
+		}
+		if !strings.Contains(out, "GOROUTINE LEAK: GET /leak left 1 goroutine(s) alive 10ms after its response") {
+			t.Errorf("%s: got %q, expected a leak report", path, out)
+		}
+		if !strings.Contains(out, ".leakyWorker(") {
+			t.Errorf("%s: the report does not contain the stack of the goroutine:\n%s", path, out)
+		}
+	}
+}
+
+func TestRequestLogFileKeepsTheRecentRequests(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "requests.log")
+	// the processes of the dev server share the file
+	for i := 0; i < vcRequestLogSize+10; i++ {
+		log := &vcRequestLog{file: file}
+		log.add(vcRequestLogEntry{Method: "GET", URL: fmt.Sprintf("/%d", i), Status: 200})
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != vcRequestLogSize {
+		t.Errorf("the file has %d lines, expected %d", n, vcRequestLogSize)
+	}
+	entries := (&vcRequestLog{file: file}).recent()
+	if len(entries) != vcRequestLogSize {
+		t.Fatalf("got %d entries, expected %d", len(entries), vcRequestLogSize)
+	}
+	first, last := entries[0].URL, entries[len(entries)-1].URL
+	if expected := fmt.Sprintf("/%d", vcRequestLogSize+9); first != "/10" || last != expected {
+		t.Errorf("got the requests %s to %s, expected /10 to %s", first, last, expected)
+	}
+}
+
+func TestDebugEndpointsAreOnlyServedUnderTheReservedPrefix(t *testing.T) {
+	handler := vcWithDebugEndpoints(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("handler"))
+	}), &vcRequestLog{file: filepath.Join(t.TempDir(), "requests.log")})
+
+	for _, test := range []struct{ path, expected string }{
+		{"/__vercel_go", "Go dev server debug endpoints"},
+		{"/__vercel_go/", "Go dev server debug endpoints"},
+		{"/api/__vercel_go/requests", "handler"},
+		{"/__vercel_gopher", "handler"},
+		// the requests of the handler are logged, unlike the debug endpoints
+		{"/__vercel_go/requests", "/api/__vercel_go/requests"},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+		if body := recorder.Body.String(); !strings.Contains(body, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.path, body, test.expected)
+		}
+	}
+}
+
+func TestStrictModeReportsWritesToTheWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"config.json", "data.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := vcWithReadOnlyDir(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/write" {
+			ioutil.WriteFile(filepath.Join(dir, "cache.txt"), []byte("cached"), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"changed":true}`), 0644)
+			os.Remove(filepath.Join(dir, "data.txt"))
+		}
+	}), dir)
+
+	for _, path := range []string{"/read", "/write", "/read"} {
+		stderr := captureStderr(t)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		out := stderr()
+
+		if path == "/read" {
+			if out != "" {
+				t.Errorf("%s: got a violation for a request without writes:\n%s", path, out)
+			}
+			continue
+		}
+		for _, expected := range []string{
+			"STRICT MODE VIOLATION: GET /write wrote to the working directory",
+			"created cache.txt", "modified config.json", "deleted data.txt",
+		} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("%s: got %q, expected %q", path, out, expected)
+			}
+		}
+	}
+}
+
+func TestStrictModeLimitsThePayloadSize(t *testing.T) {
+	handler := vcWithStrictLimits(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/echo" {
+			w.Write(body)
+		} else {
+			w.Write([]byte(strings.Repeat("x", vcMaxPayloadSize+1)))
+		}
+	}), time.Minute)
+
+	for _, test := range []struct {
+		path, body, violation string
+		status                int
+	}{
+		{"/echo", strings.Repeat("x", vcMaxPayloadSize), "", http.StatusOK},
+		{"/echo", strings.Repeat("x", vcMaxPayloadSize+1), "The request body of POST /echo exceeds the 4.5 MB limit", http.StatusRequestEntityTooLarge},
+		{"/large", "", "The response body of POST /large (4718593 bytes) exceeds the 4.5 MB limit", http.StatusInternalServerError},
+	} {
+		stderr := captureStderr(t)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", test.path, strings.NewReader(test.body)))
+		out := stderr()
+
+		if recorder.Code != test.status {
+			t.Errorf("%s with %d bytes: got the status %d, expected %d", test.path, len(test.body), recorder.Code, test.status)
+		}
+		if test.violation == "" {
+			if out != "" || recorder.Body.Len() != len(test.body) {
+				t.Errorf("%s with %d bytes: got %d bytes and the violation %q", test.path, len(test.body), recorder.Body.Len(), out)
+			}
+		} else if !strings.Contains(out, test.violation) {
+			t.Errorf("%s with %d bytes: got %q, expected %q", test.path, len(test.body), out, test.violation)
+		}
+	}
+}
+
+func TestStrictModeDiscardsTheResponseAfterTheTimeout(t *testing.T) {
+	done := make(chan struct{})
+	handler := vcWithStrictLimits(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		<-r.Context().Done()
+		w.Header().Set("X-Late", "1")
+		w.Write([]byte("late"))
+	}), 50*time.Millisecond)
+
+	stderr := captureStderr(t)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/slow", nil))
+	out := stderr()
+	<-done
+
+	if recorder.Code != http.StatusGatewayTimeout || !strings.Contains(recorder.Body.String(), "FUNCTION_INVOCATION_TIMEOUT") {
+		t.Errorf("got %d %q, expected 504 FUNCTION_INVOCATION_TIMEOUT", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("X-Late") != "" || strings.Contains(recorder.Body.String(), "late") {
+		t.Errorf("got the response of the handler after the timeout: %v %q", recorder.Header(), recorder.Body.String())
+	}
+	if expected := "GET /slow exceeded the maxDuration of 50ms"; !strings.Contains(out, expected) {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+}
@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/monorepo/shared"
+)
+
+// Handler function
+func Handler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "%s:RANDOMNESS_PLACEHOLDER", shared.Greet("users"))
+}
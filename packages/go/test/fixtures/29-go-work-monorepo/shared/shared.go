@@ -0,0 +1,11 @@
+package shared
+
+import (
+	"runtime"
+
+	"example.com/greeting"
+)
+
+func Greet(name string) string {
+	return greeting.Hello(name) + ":" + runtime.Version()
+}
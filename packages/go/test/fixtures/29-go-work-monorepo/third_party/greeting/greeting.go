@@ -0,0 +1,5 @@
+package greeting
+
+func Hello(name string) string {
+	return "hello " + name
+}
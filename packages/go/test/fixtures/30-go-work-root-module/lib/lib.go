@@ -0,0 +1,5 @@
+package lib
+
+func Name() string {
+	return "lib"
+}
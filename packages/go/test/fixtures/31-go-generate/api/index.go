@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+)
+
+//go:generate greeting
+
+// Handler func
+func Handler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "%s:RANDOMNESS_PLACEHOLDER", Greeting)
+}
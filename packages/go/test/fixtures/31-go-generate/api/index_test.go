@@ -0,0 +1,9 @@
+package handler
+
+import "testing"
+
+func TestGreeting(t *testing.T) {
+	if Greeting != "generated" {
+		t.Errorf("Greeting = %q, want %q", Greeting, "generated")
+	}
+}
@@ -0,0 +1,15 @@
+// Command greeting generates the greeting of the handler.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	src := fmt.Sprintf("// Code generated by greeting. DO NOT EDIT.\n\npackage %s\n\nconst Greeting = %q\n", os.Getenv("GOPACKAGE"), "generated")
+	if err := os.WriteFile("greeting_gen.go", []byte(src), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,5 @@
+module example.com/generate
+
+go 1.24
+
+tool example.com/generate/cmd/greeting
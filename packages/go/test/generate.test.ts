@@ -0,0 +1,181 @@
+import { join } from 'path';
+import { tmpdir } from 'os';
+import { mkdirp, pathExists, readFile, remove, writeFile } from 'fs-extra';
+import {
+  getGenerateInputs,
+  hasToolDirectives,
+  parseToolsGoImports,
+  runGoGenerate,
+} from '../src/generate';
+import { GoWrapper } from '../src/go-helpers';
+
+// A generator that writes the file of its first argument
+const generator = (pkg: string) =>
+  `package main\n\nimport "os"\n\nfunc main() { os.WriteFile(os.Args[1], []byte("package ${pkg}\\n"), 0o644) }\n`;
+
+describe('hasToolDirectives', function () {
+  it('finds tool directives', async () => {
+    const single = 'module a\n\ntool example.com/a/cmd\n';
+    const block = 'module a\n\ntool (\n\texample.com/a/cmd\n)\n';
+    const toolchain = 'module a\n\ntoolchain go1.24.1\n';
+    expect(hasToolDirectives(single)).toEqual(true);
+    expect(hasToolDirectives(block)).toEqual(true);
+    expect(hasToolDirectives(toolchain)).toEqual(false);
+  });
+});
+
+describe('parseToolsGoImports', function () {
+  it('parses the blank imports of a tools.go', async () => {
+    const contents = [
+      '//go:build tools',
+      '',
+      'package tools',
+      '',
+      'import (',
+      '\t_ "github.com/sqlc-dev/sqlc/cmd/sqlc"',
+      '\t_ "golang.org/x/tools/cmd/stringer" // enums',
+      ')',
+    ].join('\n');
+    expect(parseToolsGoImports(contents)).toEqual([
+      'github.com/sqlc-dev/sqlc/cmd/sqlc',
+      'golang.org/x/tools/cmd/stringer',
+    ]);
+  });
+
+  it('ignores a file without the tools build constraint', async () => {
+    const contents = 'package main\n\nimport _ "embed"\n';
+    expect(parseToolsGoImports(contents)).toEqual([]);
+  });
+});
+
+describe('runGoGenerate', function () {
+  let dir: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-generate-test-${name}`);
+    await mkdirp(join(dir, 'api'));
+    await writeFile(join(dir, 'api', 'index.go'), 'package api\n');
+  });
+
+  afterEach(async () => {
+    await remove(dir);
+    await remove(`${dir}-tools`);
+  });
+
+  const generate = (env = {}) =>
+    runGoGenerate({
+      go: new GoWrapper(
+        { ...process.env, GOFLAGS: '', GOWORK: 'off', ...env },
+        { cwd: join(dir, 'api') }
+      ),
+      modulePath: dir,
+      toolsPath: `${dir}-tools`,
+      env: {},
+    });
+
+  it('runs the tools of the module and reports the written files', async () => {
+    await writeFile(
+      join(dir, 'go.mod'),
+      'module example.com/app\n\ngo 1.24\n\ntool example.com/app/cmd/gen\n'
+    );
+    await mkdirp(join(dir, 'cmd', 'gen'));
+    await writeFile(join(dir, 'cmd', 'gen', 'main.go'), generator('api'));
+    await mkdirp(join(dir, 'tools', 'legacy'));
+    await writeFile(join(dir, 'tools', 'legacy', 'main.go'), generator('api'));
+    await writeFile(
+      join(dir, 'tools', 'tools.go'),
+      '//go:build tools\n\npackage tools\n\nimport _ "example.com/app/tools/legacy"\n'
+    );
+    await writeFile(
+      join(dir, 'api', 'gen.go'),
+      'package api\n\n//go:generate gen models.go\n//go:generate legacy legacy.go\n'
+    );
+
+    const generated = await generate();
+    expect(generated).toEqual({
+      written: [join('api', 'legacy.go'), join('api', 'models.go')],
+      deleted: [],
+    });
+    expect(await readFile(join(dir, 'api', 'models.go'), 'utf8')).toEqual(
+      'package api\n'
+    );
+  });
+
+  it('reports the deleted files', async () => {
+    await writeFile(join(dir, 'go.mod'), 'module example.com/app\n\ngo 1.20\n');
+    await writeFile(join(dir, 'api', 'deprecated.go'), 'package api\n');
+    await writeFile(
+      join(dir, 'api', 'gen.go'),
+      'package api\n\n//go:generate go run example.com/app/cmd/clean deprecated.go\n'
+    );
+    await mkdirp(join(dir, 'cmd', 'clean'));
+    await writeFile(
+      join(dir, 'cmd', 'clean', 'main.go'),
+      'package main\n\nimport "os"\n\nfunc main() { os.Remove(os.Args[1]) }\n'
+    );
+
+    expect(await generate()).toEqual({
+      written: [],
+      deleted: [join('api', 'deprecated.go')],
+    });
+  });
+
+  it('does not run tools from the PATH', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    await writeFile(join(dir, 'go.mod'), 'module example.com/app\n\ngo 1.13\n');
+    await mkdirp(join(dir, 'bin'));
+    await writeFile(join(dir, 'bin', 'gen'), '#!/bin/sh\ntouch "$1"\n', {
+      mode: 0o755,
+    });
+    await writeFile(
+      join(dir, 'api', 'gen.go'),
+      'package api\n\n//go:generate gen models.go\n'
+    );
+
+    await expect(
+      generate({ PATH: `${join(dir, 'bin')}:${process.env.PATH}` })
+    ).rejects.toThrow();
+    expect(await pathExists(join(dir, 'api', 'models.go'))).toEqual(false);
+  });
+});
+
+describe('getGenerateInputs', function () {
+  let dir: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-generate-inputs-test-${name}`);
+    await mkdirp(join(dir, 'api', 'sql'));
+    await mkdirp(join(dir, 'lib', '.git'));
+    await writeFile(
+      join(dir, 'go.work'),
+      'go 1.22\n\nuse (\n\t./api\n\t./lib\n)\n'
+    );
+    await writeFile(join(dir, 'api', 'go.mod'), 'module example.com/api\n');
+    await writeFile(join(dir, 'api', 'sql', 'schema.sql'), '');
+    await writeFile(join(dir, 'lib', 'go.mod'), 'module example.com/lib\n');
+    await writeFile(join(dir, 'lib', '.git', 'HEAD'), '');
+  });
+
+  afterEach(async () => {
+    await remove(dir);
+  });
+
+  it('lists the files of the module and of its workspace', async () => {
+    expect(
+      await getGenerateInputs(join(dir, 'api'), join(dir, 'go.work'))
+    ).toEqual([
+      join(dir, 'api', 'go.mod'),
+      join(dir, 'api', 'sql', 'schema.sql'),
+      join(dir, 'lib', 'go.mod'),
+    ]);
+    expect(await getGenerateInputs(join(dir, 'api'))).toEqual([
+      join(dir, 'api', 'go.mod'),
+      join(dir, 'api', 'sql', 'schema.sql'),
+    ]);
+  });
+});
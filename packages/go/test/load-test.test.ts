@@ -0,0 +1,99 @@
+import { join } from 'path';
+import { tmpdir } from 'os';
+import { mkdirp, remove, writeFile } from 'fs-extra';
+import { runLoadTest } from '../src/load-test';
+import { parseLoadTestArgs } from '../src/load-test-cli';
+
+jest.setTimeout(2 * 60 * 1000);
+
+describe('parseLoadTestArgs', function () {
+  it('parses the options and the request', async () => {
+    const args = parseLoadTestArgs([
+      '-c',
+      '4',
+      '--duration',
+      '2',
+      '-X',
+      'POST',
+      '-p',
+      '/api?a=1',
+      '-H',
+      'Content-Type: application/json',
+      '--body',
+      '{}',
+      '--json',
+      'api/index.go',
+    ]);
+    expect(args).toEqual({
+      options: {
+        target: 'dev',
+        entrypoint: 'api/index.go',
+        concurrency: 4,
+        duration: 2,
+        request: {
+          method: 'POST',
+          path: '/api?a=1',
+          headers: { 'Content-Type': 'application/json' },
+          body: '{}',
+        },
+      },
+      json: true,
+      help: false,
+    });
+  });
+
+  it('throws on invalid arguments', async () => {
+    expect(() => parseLoadTestArgs([])).toThrow('Missing the entrypoint');
+    expect(() => parseLoadTestArgs(['-c', '0', 'api/index.go'])).toThrow(
+      'Invalid concurrency "0"'
+    );
+    expect(() =>
+      parseLoadTestArgs(['--target', 'lambda', 'api/index.go'])
+    ).toThrow('Invalid target "lambda"');
+    expect(() => parseLoadTestArgs(['-H', 'Accept', 'api/index.go'])).toThrow(
+      'Invalid header "Accept"'
+    );
+  });
+});
+
+describe('runLoadTest', function () {
+  let workPath: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    workPath = join(tmpdir(), `vc-go-load-test-${name}`);
+    await mkdirp(join(workPath, 'api'));
+    await writeFile(
+      join(workPath, 'go.mod'),
+      'module example.com/app\n\ngo 1.20\n'
+    );
+    await writeFile(
+      join(workPath, 'api', 'index.go'),
+      'package api\n\nimport "net/http"\n\nfunc Handler(w http.ResponseWriter, r *http.Request) {\n\tif r.Method != http.MethodPost {\n\t\tw.WriteHeader(http.StatusMethodNotAllowed)\n\t\treturn\n\t}\n\tw.Write([]byte("ok"))\n}\n'
+    );
+  });
+
+  afterEach(async () => {
+    await remove(workPath);
+  });
+
+  it('load tests the dev server', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    const result = await runLoadTest({
+      target: 'dev',
+      entrypoint: 'api/index.go',
+      workPath,
+      concurrency: 2,
+      duration: 1,
+      request: { method: 'POST', path: '/api' },
+    });
+    expect(result.target).toMatch(/^http:\/\/127\.0\.0\.1:\d+$/);
+    expect(result.requests).toBeGreaterThan(0);
+    expect(Object.keys(result.statusCodes)).toEqual(['200']);
+    expect(result.errors).toEqual(0);
+    expect(result.allocsPerRequest).toBeGreaterThan(0);
+  });
+});
@@ -0,0 +1,136 @@
+import execa from 'execa';
+import { join } from 'path';
+import { tmpdir } from 'os';
+import { mkdirp, remove, writeFile } from 'fs-extra';
+import { glob } from '@vercel/build-utils';
+import { build } from '../src/index';
+import { GoWrapper } from '../src/go-helpers';
+
+jest.setTimeout(4 * 60 * 1000);
+
+const handler = (
+  pkg: string,
+  imports = '',
+  body = 'w.Write([]byte("ok"))'
+) =>
+  `package ${pkg}\n\nimport (\n\t"net/http"\n${imports})\n\nfunc Handler(w http.ResponseWriter, r *http.Request) {\n\t${body}\n}\n`;
+
+// `go mod vendor` only vendors imported packages, so the bridge that the
+// generated `main.go` imports is imported by a `tools.go`
+const tools = (pkg: string) =>
+  `//go:build tools\n\npackage ${pkg}\n\nimport _ "github.com/vercel/go-bridge/go/bridge"\n`;
+
+describe('build with GO_BUILD_OFFLINE', function () {
+  let workPath: string;
+  let calls: string[];
+  const { mod, get } = GoWrapper.prototype;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    workPath = join(tmpdir(), `vc-go-offline-test-${name}`);
+    await mkdirp(workPath);
+
+    calls = [];
+    GoWrapper.prototype.mod = function () {
+      calls.push('go mod tidy');
+      return mod.call(this);
+    };
+    GoWrapper.prototype.get = function (src?: string) {
+      calls.push('go get');
+      return get.call(this, src);
+    };
+  });
+
+  afterEach(async () => {
+    GoWrapper.prototype.mod = mod;
+    GoWrapper.prototype.get = get;
+    await remove(workPath);
+  });
+
+  // prepares the project with the network, like a CI step before the build
+  const online = (dir: string, ...args: string[]) =>
+    execa('go', args, {
+      cwd: join(workPath, dir),
+      env: { GOMODCACHE: join(workPath, '.modcache') },
+    });
+
+  const buildOffline = async (entrypoint: string) =>
+    build({
+      files: await glob('**', { cwd: workPath, ignore: ['.modcache/**'] }),
+      entrypoint,
+      workPath,
+      config: {},
+      meta: {
+        skipDownload: true,
+        // the Go on the PATH, which cannot be downloaded offline
+        env: { GO_BUILD_OFFLINE: '1', GOTOOLCHAIN: 'local' },
+      },
+    });
+
+  it('builds a vendored module without tidying it', async () => {
+    await writeFile(
+      join(workPath, 'go.mod'),
+      'module example.com/app\n\ngo 1.20\n'
+    );
+    await mkdirp(join(workPath, 'api'));
+    await writeFile(join(workPath, 'api', 'index.go'), handler('api'));
+    await writeFile(join(workPath, 'tools.go'), tools('app'));
+    await online('.', 'get', 'github.com/vercel/go-bridge/go/bridge');
+    await online('.', 'mod', 'vendor');
+    await remove(join(workPath, '.modcache'));
+
+    const { output } = await buildOffline('api/index.go');
+    expect(Object.keys(output.files || {})).toContain('bootstrap');
+    expect(calls).toEqual([]);
+  });
+
+  it('builds a module of a vendored workspace without go get', async () => {
+    await writeFile(
+      join(workPath, 'go.work'),
+      'go 1.22\n\nuse (\n\t./api\n\t./lib\n)\n'
+    );
+    await mkdirp(join(workPath, 'api'));
+    await mkdirp(join(workPath, 'lib'));
+    await writeFile(
+      join(workPath, 'api', 'go.mod'),
+      'module example.com/api\n\ngo 1.22\n'
+    );
+    await writeFile(
+      join(workPath, 'api', 'index.go'),
+      handler('api', '\n\t"example.com/lib"\n', 'w.Write([]byte(lib.Name))')
+    );
+    await writeFile(join(workPath, 'api', 'tools.go'), tools('api'));
+    await writeFile(
+      join(workPath, 'lib', 'go.mod'),
+      'module example.com/lib\n\ngo 1.22\n'
+    );
+    await writeFile(
+      join(workPath, 'lib', 'lib.go'),
+      'package lib\n\nconst Name = "lib"\n'
+    );
+    await online('api', 'get', 'github.com/vercel/go-bridge/go/bridge');
+    await online('.', 'work', 'vendor');
+    await remove(join(workPath, '.modcache'));
+
+    const { output } = await buildOffline('api/index.go');
+    expect(Object.keys(output.files || {})).toContain('bootstrap');
+    expect(calls).toEqual([]);
+  });
+
+  it('lists the packages missing from the module cache', async () => {
+    await writeFile(
+      join(workPath, 'go.mod'),
+      'module example.com/app\n\ngo 1.20\n\nrequire github.com/vercel/go-bridge v0.0.1\n'
+    );
+    await mkdirp(join(workPath, 'api'));
+    await writeFile(join(workPath, 'api', 'index.go'), handler('api'));
+
+    await expect(buildOffline('api/index.go')).rejects.toThrow(
+      'The following packages are not available offline (`GO_BUILD_OFFLINE` is set).'
+    );
+    await expect(buildOffline('api/index.go')).rejects.toThrow(
+      '  - github.com/vercel/go-bridge/go/bridge: '
+    );
+    expect(calls).toEqual([]);
+  });
+});
@@ -15,6 +15,11 @@ describe('parseGoModVersion', function () {
     expect(version.go).toEqual('1.16.15');
     expect(version.toolchain).toBeUndefined();
   });
+  it('returns the initial release of a version newer than the version map', async () => {
+    const version = parseGoModVersion('go 1.30');
+    expect(version.go).toEqual('1.30.0');
+    expect(version.toolchain).toBeUndefined();
+  });
   it('returns correct version when unrelated line exists', async () => {
     const version = parseGoModVersion('something\ngo 1.21.1\nsomething');
     expect(version.go).toEqual('1.21.1');
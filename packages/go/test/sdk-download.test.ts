@@ -0,0 +1,197 @@
+import tar from 'tar';
+import { createHash } from 'crypto';
+import { createServer, Server } from 'http';
+import { AddressInfo } from 'net';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import {
+  chmod,
+  mkdirp,
+  pathExists,
+  readFile,
+  remove,
+  writeFile,
+} from 'fs-extra';
+import { download } from '../src/go-helpers';
+
+const version = '1.23.2';
+const arch = process.arch === 'x64' ? 'amd64' : process.arch;
+const filename = `go${version}.${process.platform}-${arch}.tar.gz`;
+
+describe('download', function () {
+  let dir: string;
+  let mirror: string;
+  let server: Server;
+  let archive: Buffer;
+  let sha256: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-sdk-test-${name}`);
+
+    // a stand-in for the Go SDK archive
+    const sdk = join(dir, 'sdk');
+    await mkdirp(join(sdk, 'go', 'bin'));
+    await writeFile(join(sdk, 'go', 'bin', 'go'), '#!/bin/sh\n');
+    await chmod(join(sdk, 'go', 'bin', 'go'), 0o755);
+    await mkdirp(join(dir, 'mirror'));
+    await tar.create(
+      { gzip: true, cwd: sdk, file: join(dir, 'mirror', filename) },
+      ['go']
+    );
+    archive = await readFile(join(dir, 'mirror', filename));
+    sha256 = createHash('sha256').update(archive).digest('hex');
+
+    server = createServer(async (req, res) => {
+      const file = join(dir, 'mirror', req.url || '');
+      if (!(await pathExists(file))) {
+        res.statusCode = 404;
+        res.end();
+        return;
+      }
+      res.end(await readFile(file));
+    });
+    await new Promise<void>(resolve => server.listen(0, '127.0.0.1', resolve));
+    const { port } = server.address() as AddressInfo;
+    mirror = `http://127.0.0.1:${port}`;
+  });
+
+  afterEach(async () => {
+    await new Promise(resolve => server.close(resolve));
+    await remove(dir);
+  });
+
+  it('installs an archive matching the manifest', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    const dest = join(dir, 'go');
+    await download({
+      dest,
+      version,
+      mirror,
+      checksums: { [filename]: sha256 },
+    });
+    expect(await pathExists(join(dest, 'bin', 'go'))).toEqual(true);
+  });
+
+  it('falls back to the official checksum file', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    await mkdirp(join(dir, 'mirror', 'official'));
+    await writeFile(
+      join(dir, 'mirror', 'official', `${filename}.sha256`),
+      `${sha256}  ${filename}\n`
+    );
+    const dest = join(dir, 'go');
+    await download({
+      dest,
+      version,
+      mirror,
+      checksums: {},
+      checksumOrigin: `${mirror}/official`,
+      fetchChecksum: true,
+    });
+    expect(await pathExists(join(dest, 'bin', 'go'))).toEqual(true);
+  });
+
+  it('does not fetch the checksum file without opting in', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    await mkdirp(join(dir, 'mirror', 'official'));
+    await writeFile(
+      join(dir, 'mirror', 'official', `${filename}.sha256`),
+      `${sha256}  ${filename}\n`
+    );
+    const dest = join(dir, 'go');
+    await expect(
+      download({
+        dest,
+        version,
+        mirror,
+        checksums: {},
+        checksumOrigin: `${mirror}/official`,
+      })
+    ).rejects.toThrow(
+      `Cannot verify ${filename}: it is not in the checksum manifest`
+    );
+    expect(await pathExists(dest)).toEqual(false);
+  });
+
+  it('does not trust the checksum file of the mirror', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    await writeFile(
+      join(dir, 'mirror', `${filename}.sha256`),
+      `${sha256}  ${filename}\n`
+    );
+    const dest = join(dir, 'go');
+    await expect(
+      download({
+        dest,
+        version,
+        mirror,
+        checksums: {},
+        checksumOrigin: `${mirror}/official`,
+        fetchChecksum: true,
+      })
+    ).rejects.toThrow(`Cannot verify ${filename}`);
+    expect(await pathExists(dest)).toEqual(false);
+  });
+
+  it('installs from a local directory', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    const dest = join(dir, 'go');
+    await download({
+      dest,
+      version,
+      mirror: join(dir, 'mirror'),
+      checksums: { [filename]: sha256 },
+    });
+    expect(await pathExists(join(dest, 'bin', 'go'))).toEqual(true);
+  });
+
+  it('rejects a tampered archive', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    const tampered = Buffer.concat([archive, Buffer.from('tampered')]);
+    await writeFile(join(dir, 'mirror', filename), tampered);
+
+    const dest = join(dir, 'go');
+    await expect(
+      download({ dest, version, mirror, checksums: { [filename]: sha256 } })
+    ).rejects.toThrow(`Checksum mismatch for ${mirror}/${filename}`);
+    expect(await pathExists(dest)).toEqual(false);
+  });
+
+  it('rejects an archive without a checksum', async () => {
+    if (process.platform === 'win32') {
+      console.log('Skipping test on windows');
+      return;
+    }
+    const dest = join(dir, 'go');
+    await expect(
+      download({
+        dest,
+        version,
+        mirror,
+        checksums: {},
+        checksumOrigin: mirror,
+        fetchChecksum: true,
+      })
+    ).rejects.toThrow(`Cannot verify ${filename}`);
+    expect(await pathExists(dest)).toEqual(false);
+  });
+});
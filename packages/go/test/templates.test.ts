@@ -0,0 +1,11 @@
+import { readFile } from 'fs-extra';
+import { join } from 'path';
+
+describe('main.go', function () {
+  it('does not include the dev server debug endpoints', async () => {
+    const bootstrap = await readFile(join(__dirname, '../main.go'), 'utf8');
+    expect(bootstrap).not.toContain('__vercel_go');
+    expect(bootstrap).not.toContain('net/http/pprof');
+    expect(bootstrap).not.toContain('runtime/pprof');
+  });
+});
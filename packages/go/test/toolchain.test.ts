@@ -0,0 +1,195 @@
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { mkdirp, remove, writeFile } from 'fs-extra';
+import { getGoRequirement } from '../src/go-helpers';
+import {
+  compareGoVersions,
+  maxGoVersion,
+  parseGoToolchainEnv,
+  selectGoToolchain,
+} from '../src/toolchain';
+
+describe('compareGoVersions', function () {
+  it('orders language versions, prereleases and releases', async () => {
+    const sorted = ['1.22.1', '1.21', '1.22rc1', '1.22', '1.22beta1', '1.22.0'];
+    sorted.sort(compareGoVersions);
+    expect(sorted).toEqual([
+      '1.21',
+      '1.22',
+      '1.22beta1',
+      '1.22rc1',
+      '1.22.0',
+      '1.22.1',
+    ]);
+  });
+  it('compares numerically', async () => {
+    expect(compareGoVersions('1.9', '1.10')).toBeLessThan(0);
+    expect(compareGoVersions('go1.22.3', '1.22.3')).toEqual(0);
+  });
+  it('returns the newest version', async () => {
+    expect(maxGoVersion(undefined, '1.22', '1.21.5')).toEqual('1.22');
+    expect(maxGoVersion(undefined)).toBeUndefined();
+  });
+});
+
+describe('parseGoToolchainEnv', function () {
+  it('returns undefined if not set', async () => {
+    expect(parseGoToolchainEnv(undefined)).toBeUndefined();
+    expect(parseGoToolchainEnv('')).toBeUndefined();
+  });
+  it('parses the switch modes', async () => {
+    expect(parseGoToolchainEnv('local')).toEqual({ version: 'local' });
+    expect(parseGoToolchainEnv('auto')).toEqual({
+      version: 'local',
+      switch: 'auto',
+    });
+    expect(parseGoToolchainEnv('path')).toEqual({
+      version: 'local',
+      switch: 'path',
+    });
+  });
+  it('parses a version with a suffix', async () => {
+    expect(parseGoToolchainEnv('go1.22.3')).toEqual({ version: '1.22.3' });
+    expect(parseGoToolchainEnv('go1.22.3+auto')).toEqual({
+      version: '1.22.3',
+      switch: 'auto',
+    });
+    expect(parseGoToolchainEnv('local+path')).toEqual({
+      version: 'local',
+      switch: 'path',
+    });
+  });
+  it('throws on an invalid value', async () => {
+    expect(() => parseGoToolchainEnv('1.22.3')).toThrow(
+      'Invalid GOTOOLCHAIN "1.22.3"'
+    );
+    expect(() => parseGoToolchainEnv('go1.22.3+never')).toThrow(
+      'Invalid GOTOOLCHAIN "go1.22.3+never"'
+    );
+  });
+});
+
+describe('selectGoToolchain', function () {
+  const required = { minimum: '1.22', version: '1.22.8' };
+  const latest = '1.23.2';
+
+  it('selects the required version without GOTOOLCHAIN', async () => {
+    const selection = selectGoToolchain({ required, source: 'go.work', latest });
+    expect(selection).toEqual({
+      version: '1.22.8',
+      download: true,
+      reason: 'required by go.work',
+    });
+    expect(selectGoToolchain({ latest }).version).toEqual('1.23.2');
+  });
+  it('selects a pinned version that is new enough', async () => {
+    const selection = selectGoToolchain({
+      required,
+      gotoolchain: parseGoToolchainEnv('go1.23.0'),
+      latest,
+    });
+    expect(selection).toEqual({
+      version: '1.23.0',
+      download: true,
+      reason: 'GOTOOLCHAIN=go1.23.0',
+    });
+  });
+  it('selects the go on the PATH', async () => {
+    const selection = selectGoToolchain({
+      required,
+      gotoolchain: parseGoToolchainEnv('local'),
+      latest,
+      local: '1.22.3',
+    });
+    expect(selection.version).toEqual('local');
+    expect(selection.download).toEqual(false);
+  });
+  it('switches to the required version', async () => {
+    const auto = selectGoToolchain({
+      required,
+      gotoolchain: parseGoToolchainEnv('auto'),
+      latest,
+      local: '1.21.5',
+    });
+    expect(auto).toEqual({
+      version: '1.22.8',
+      download: true,
+      reason: 'required by go.mod (GOTOOLCHAIN=local+auto)',
+    });
+    const path = selectGoToolchain({
+      required,
+      gotoolchain: parseGoToolchainEnv('go1.21.5+path'),
+      latest,
+    });
+    expect(path.version).toEqual('1.22.8');
+    expect(path.download).toEqual(false);
+  });
+  it('throws if the toolchain is too old and may not switch', async () => {
+    expect(() =>
+      selectGoToolchain({
+        required,
+        gotoolchain: parseGoToolchainEnv('go1.21.5'),
+        latest,
+      })
+    ).toThrow('go.mod requires go >= 1.22, but GOTOOLCHAIN=go1.21.5 selects');
+    expect(() =>
+      selectGoToolchain({
+        required,
+        gotoolchain: parseGoToolchainEnv('local'),
+        latest,
+      })
+    ).toThrow('GOTOOLCHAIN=local is set and go is not on the PATH');
+  });
+});
+
+describe('getGoRequirement', function () {
+  let dir: string;
+
+  beforeEach(async () => {
+    const name = Math.random().toString(32).substring(2);
+    dir = join(tmpdir(), `vc-go-requirement-test-${name}`);
+    await mkdirp(join(dir, 'api'));
+    await mkdirp(join(dir, 'lib'));
+  });
+
+  afterEach(async () => {
+    await remove(dir);
+  });
+
+  it('resolves the initial release to the latest patch release', async () => {
+    await writeFile(join(dir, 'api', 'go.mod'), 'module api\n\ngo 1.22.0\n');
+    const requirement = await getGoRequirement(join(dir, 'api'), dir, {});
+    expect(requirement).toEqual({
+      minimum: '1.22.0',
+      version: '1.22.8',
+      source: join(dir, 'api', 'go.mod'),
+    });
+  });
+
+  it('selects the exact release of a toolchain line', async () => {
+    await writeFile(
+      join(dir, 'api', 'go.mod'),
+      'module api\n\ngo 1.21\n\ntoolchain go1.22.0\n'
+    );
+    const requirement = await getGoRequirement(join(dir, 'api'), dir, {});
+    expect(requirement && requirement.version).toEqual('1.22.0');
+  });
+
+  it('ignores the toolchain lines of the workspace modules', async () => {
+    await writeFile(
+      join(dir, 'go.work'),
+      'go 1.21\n\nuse (\n\t./api\n\t./lib\n)\n'
+    );
+    await writeFile(join(dir, 'api', 'go.mod'), 'module api\n\ngo 1.21\n');
+    await writeFile(
+      join(dir, 'lib', 'go.mod'),
+      'module lib\n\ngo 1.21\n\ntoolchain go1.23.2\n'
+    );
+    const requirement = await getGoRequirement(join(dir, 'api'), dir, {});
+    expect(requirement).toEqual({
+      minimum: '1.21',
+      version: '1.21.13',
+      source: join(dir, 'go.work'),
+    });
+  });
+});